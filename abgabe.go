@@ -2,6 +2,10 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
 	"unicode"
 )
 import "strconv"
@@ -25,6 +29,11 @@ type Stmt interface {
 var varName string
 var inputLength int
 var errorLength int
+var errorDetail string
+var inputSource string
+
+// Destination of print statements
+var output io.Writer = os.Stdout
 
 type Bool bool
 type Num int
@@ -35,31 +44,64 @@ type Or [2]Exp
 type Neg [1]Exp
 type Equ [2]Exp
 type Les [2]Exp
+type Minus [2]Exp
+type Neq [2]Exp
+type Leq [2]Exp
+type Gre [2]Exp
+type Geq [2]Exp
 type Var string
 
 type Block struct {
-	s Stmt
+	s    Stmt
+	span Span
 }
 type ComS [2]Stmt
 type Decl struct {
-	lhs string
-	rhs Exp
+	lhs  string
+	rhs  Exp
+	ty   Type // annotated type, TyIllTyped if none was given
+	zero bool // var x T, rhs is the zero value of ty
+	span Span
 }
 type Assign struct {
 	name  string
 	value Exp
+	span  Span
 }
 type While struct {
-	e Exp
-	b Block
+	e    Exp
+	b    Block
+	inv  Exp // loop invariant, nil if none was given
+	span Span
 }
 type IfEl struct {
-	e  Exp
-	b1 Block
-	b2 Block
+	e    Exp
+	b1   Block
+	b2   Block
+	span Span
 }
 type Print struct {
-	e Exp
+	e    Exp
+	span Span
+}
+type Assert struct {
+	e    Exp
+	span Span
+}
+type Assume struct {
+	e    Exp
+	span Span
+}
+
+// Source span, lines and columns start at 1, line 0 means unknown
+type Span struct {
+	line, col       int
+	endLine, endCol int
+}
+
+// Empty statement, skip in the source or created by the optimizer
+type Skip struct {
+	span Span
 }
 
 type ValState map[string]Val
@@ -130,8 +172,35 @@ const (
 	Variables      ErrorCodeExpression = 10
 	Condition      ErrorCodeExpression = 11
 	BlockT         ErrorCodeExpression = 12
+	Subtraction    ErrorCodeExpression = 13
+	Inequality     ErrorCodeExpression = 14
+	LesserEqual    ErrorCodeExpression = 15
+	Greater        ErrorCodeExpression = 16
+	GreaterEqual   ErrorCodeExpression = 17
+	Annotation     ErrorCodeExpression = 18
+	Redeclaration  ErrorCodeExpression = 19
+	BranchConflict ErrorCodeExpression = 20
 )
 
+// Keyword used for a type annotation in the source
+func typeKeyword(t Type) string {
+	switch t {
+	case TyInt:
+		return "int"
+	case TyBool:
+		return "bool"
+	}
+	return "illtyped"
+}
+
+// Zero value of a type, used by "var x T"
+func zeroExp(t Type) Exp {
+	if t == TyBool {
+		return Bool(false)
+	}
+	return Num(0)
+}
+
 func showType(t Type) string {
 	var s string
 	switch {
@@ -242,6 +311,71 @@ func (e Les) pretty() string {
 	return x
 }
 
+// Subtraction
+func (e Minus) pretty() string {
+
+	var x string
+	x = "("
+	x += e[0].pretty()
+	x += "-"
+	x += e[1].pretty()
+	x += ")"
+
+	return x
+}
+
+// Inequality
+func (e Neq) pretty() string {
+
+	var x string
+	x = "("
+	x += e[0].pretty()
+	x += "!="
+	x += e[1].pretty()
+	x += ")"
+
+	return x
+}
+
+// Lesser Equal Test
+func (e Leq) pretty() string {
+
+	var x string
+	x = "("
+	x += e[0].pretty()
+	x += "<="
+	x += e[1].pretty()
+	x += ")"
+
+	return x
+}
+
+// Greater Test
+func (e Gre) pretty() string {
+
+	var x string
+	x = "("
+	x += e[0].pretty()
+	x += ">"
+	x += e[1].pretty()
+	x += ")"
+
+	return x
+}
+
+// Greater Equal Test
+func (e Geq) pretty() string {
+
+	var x string
+	x = "("
+	x += e[0].pretty()
+	x += ">="
+	x += e[1].pretty()
+	x += ")"
+
+	return x
+}
+
 // Vars
 
 func (x Var) pretty() string {
@@ -259,8 +393,15 @@ func (s ComS) pretty() string {
 
 // Variable declaration
 func (e Decl) pretty() string {
+	if e.zero {
+		return "var " + e.lhs + " " + typeKeyword(e.ty)
+	}
 	var x string
 	x = e.lhs
+	if e.ty != TyIllTyped {
+		x += " : "
+		x += typeKeyword(e.ty)
+	}
 	x += " := "
 	x += e.rhs.pretty()
 	return x
@@ -280,6 +421,10 @@ func (w While) pretty() string {
 	var x string
 	x = " while "
 	x += w.e.pretty()
+	if w.inv != nil {
+		x += " invariant "
+		x += w.inv.pretty()
+	}
 	x += " { "
 	x += w.b.pretty()
 	x += " } "
@@ -308,6 +453,28 @@ func (e Print) pretty() string {
 	return x
 }
 
+// Assert and assume
+
+func (e Assert) pretty() string {
+	var x string
+	x = "assert "
+	x += e.e.pretty()
+	return x
+}
+
+func (e Assume) pretty() string {
+	var x string
+	x = "assume "
+	x += e.e.pretty()
+	return x
+}
+
+// Skip
+
+func (e Skip) pretty() string {
+	return "skip"
+}
+
 // Block
 
 func (b Block) pretty() string {
@@ -429,10 +596,87 @@ func (e Les) eval(s ValState) Val {
 	return mkUndefined()
 }
 
+// Subtraction
+
+func (e Minus) eval(s ValState) Val {
+	n1 := e[0].eval(s)
+	n2 := e[1].eval(s)
+	if n1.flag == ValueInt && n2.flag == ValueInt {
+		return mkInt(n1.valI - n2.valI)
+	}
+	return mkUndefined()
+}
+
+// Inequality Test
+
+func (e Neq) eval(s ValState) Val {
+	b1 := e[0].eval(s)
+	b2 := e[1].eval(s)
+	switch {
+	case b1.flag == ValueBool && b2.flag == ValueBool:
+		if b1.valB != b2.valB {
+			return mkBool(true)
+		}
+		return mkBool(false)
+	case b1.flag == ValueInt && b2.flag == ValueInt:
+		if b1.valI != b2.valI {
+			return mkBool(true)
+		}
+		return mkBool(false)
+	}
+	return mkUndefined()
+}
+
+// Lesser Equal Test
+
+func (e Leq) eval(s ValState) Val {
+	b1 := e[0].eval(s)
+	b2 := e[1].eval(s)
+	if b1.flag == ValueInt && b2.flag == ValueInt {
+		if b1.valI <= b2.valI {
+			return mkBool(true)
+		}
+		return mkBool(false)
+	}
+	return mkUndefined()
+}
+
+// Greater Test
+
+func (e Gre) eval(s ValState) Val {
+	b1 := e[0].eval(s)
+	b2 := e[1].eval(s)
+	if b1.flag == ValueInt && b2.flag == ValueInt {
+		if b1.valI > b2.valI {
+			return mkBool(true)
+		}
+		return mkBool(false)
+	}
+	return mkUndefined()
+}
+
+// Greater Equal Test
+
+func (e Geq) eval(s ValState) Val {
+	b1 := e[0].eval(s)
+	b2 := e[1].eval(s)
+	if b1.flag == ValueInt && b2.flag == ValueInt {
+		if b1.valI >= b2.valI {
+			return mkBool(true)
+		}
+		return mkBool(false)
+	}
+	return mkUndefined()
+}
+
 // vars
 
 func (x Var) eval(s ValState) Val {
-	return s[(string)(x)]
+	v, ok := s[(string)(x)]
+	if !ok {
+		return mkUndefined()
+	}
+	return v
 }
 
 // Exp
@@ -445,6 +689,7 @@ func (x ComS) eval(s ValState) {
 
 // Variable declaration
 func (decl Decl) eval(s ValState) {
+	beforeStmt(decl, decl.span, s)
 	v := decl.rhs.eval(s)
 	x := (string)(decl.lhs)
 	s[x] = v
@@ -453,6 +698,7 @@ func (decl Decl) eval(s ValState) {
 
 // Variable assignment
 func (assign Assign) eval(s ValState) {
+	beforeStmt(assign, assign.span, s)
 	v := s[assign.name]
 	v = assign.value.eval(s)
 	s[assign.name] = v
@@ -460,6 +706,7 @@ func (assign Assign) eval(s ValState) {
 
 // While
 func (w While) eval(s ValState) {
+	beforeStmt(w, w.span, s)
 	if w.e.eval(s).valB {
 		w.b.eval(s)
 		w.eval(s)
@@ -468,6 +715,7 @@ func (w While) eval(s ValState) {
 
 // If-then-else
 func (ifel IfEl) eval(s ValState) {
+	beforeStmt(ifel, ifel.span, s)
 	if ifel.e.eval(s).valB {
 		ifel.b1.eval(s)
 	} else {
@@ -477,14 +725,38 @@ func (ifel IfEl) eval(s ValState) {
 
 // Print
 func (p Print) eval(s ValState) {
+	beforeStmt(p, p.span, s)
 	p1 := p.e.eval(s)
 	switch p1.flag {
 	case ValueInt:
-		fmt.Printf("\n %d", p1.valI)
+		fmt.Fprintf(output, "\n %d", p1.valI)
 	case ValueBool:
-		fmt.Printf("\n %t", p1.valB)
+		fmt.Fprintf(output, "\n %t", p1.valB)
+	}
+
+}
+
+// Assert aborts the program with an AssertionError if e does not hold
+func (e Assert) eval(s ValState) {
+	beforeStmt(e, e.span, s)
+	v := e.e.eval(s)
+	if v.flag == ValueBool && v.valB {
+		return
+	}
+	var vals []string
+	for _, x := range expVars(e.e) {
+		vals = append(vals, x+" = "+showVal(Var(x).eval(s)))
 	}
+	panic(AssertionError{e.e, e.span, vals})
+}
+
+// Assume is only used by the verifier
+func (e Assume) eval(s ValState) {
+	beforeStmt(e, e.span, s)
+}
 
+// Skip
+func (e Skip) eval(s ValState) {
 }
 
 // Block
@@ -572,6 +844,59 @@ func (e Les) infer(t TyState) (Type, ErrorCodeExpression) {
 	return TyIllTyped, Lesser
 }
 
+// Subtraction
+func (e Minus) infer(t TyState) (Type, ErrorCodeExpression) {
+	t1, _ := e[0].infer(t)
+	t2, _ := e[1].infer(t)
+	if t1 == TyInt && t2 == TyInt {
+		return TyInt, Subtraction
+	}
+	return TyIllTyped, Subtraction
+}
+
+// Inequality Test
+func (e Neq) infer(t TyState) (Type, ErrorCodeExpression) {
+	t1, _ := e[0].infer(t)
+	t2, _ := e[1].infer(t)
+	if t1 == TyBool && t2 == TyBool {
+		return TyBool, Inequality
+	}
+	if t1 == TyInt && t2 == TyInt {
+		return TyBool, Inequality
+	}
+	return TyIllTyped, Inequality
+}
+
+// Lesser Equal Test
+func (e Leq) infer(t TyState) (Type, ErrorCodeExpression) {
+	t1, _ := e[0].infer(t)
+	t2, _ := e[1].infer(t)
+	if t1 == TyInt && t2 == TyInt {
+		return TyBool, LesserEqual
+	}
+	return TyIllTyped, LesserEqual
+}
+
+// Greater Test
+func (e Gre) infer(t TyState) (Type, ErrorCodeExpression) {
+	t1, _ := e[0].infer(t)
+	t2, _ := e[1].infer(t)
+	if t1 == TyInt && t2 == TyInt {
+		return TyBool, Greater
+	}
+	return TyIllTyped, Greater
+}
+
+// Greater Equal Test
+func (e Geq) infer(t TyState) (Type, ErrorCodeExpression) {
+	t1, _ := e[0].infer(t)
+	t2, _ := e[1].infer(t)
+	if t1 == TyInt && t2 == TyInt {
+		return TyBool, GreaterEqual
+	}
+	return TyIllTyped, GreaterEqual
+}
+
 // Vars
 
 func (x Var) infer(t TyState) (Type, ErrorCodeExpression) {
@@ -580,17 +905,95 @@ func (x Var) infer(t TyState) (Type, ErrorCodeExpression) {
 	if ok {
 		return ty, Variables
 	} else {
+		setErrorDetail(undeclared(y, t))
 		return TyIllTyped, Variables
 	}
 
 }
 
+// Error details
+
+// Only the first detail is kept, it belongs to the first error found
+func setErrorDetail(msg string) {
+	if errorDetail == "" {
+		errorDetail = msg
+	}
+}
+
+func undeclared(x string, t TyState) string {
+	msg := "variable " + x + " not declared"
+	if y := closestName(x, t); y != "" {
+		msg += ", did you mean " + y + "?"
+	}
+	return msg
+}
+
+// Declared name with the smallest edit distance to x, "" if none is close enough
+func closestName(x string, t TyState) string {
+	var names []string
+	for y := range t {
+		names = append(names, y)
+	}
+	sort.Strings(names)
+	best := ""
+	bestD := len(x)/2 + 1
+	for _, y := range names {
+		d := editDistance(x, y)
+		if d < bestD {
+			best = y
+			bestD = d
+		}
+	}
+	return best
+}
+
+// Levenshtein distance
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Scopes
+
+// Names declared in each enclosing block, innermost last
+var declScopes []map[string]bool
+
 // Check decl
 
 func (e Decl) check(t TyState) (bool, ErrorCodeStatement, ErrorCodeExpression) {
 	v, vP := e.rhs.infer(t)
 	x := (string)(e.lhs)
-	t[x] = v
+	if n := len(declScopes); n > 0 {
+		if declScopes[n-1][x] {
+			setErrorDetail("variable " + x + " already declared in this scope")
+			return false, DECL, Redeclaration
+		}
+		declScopes[n-1][x] = true
+	}
+	if e.ty != TyIllTyped {
+		t[x] = e.ty
+		if v != TyIllTyped && v != e.ty {
+			setErrorDetail("variable " + x + " declared as " + showType(e.ty) + " but initialized with " + showType(v))
+			return false, DECL, Annotation
+		}
+	} else {
+		t[x] = v
+	}
 	if v != TyIllTyped {
 		return true, DECL, vP
 	}
@@ -610,6 +1013,7 @@ func (assign Assign) check(t TyState) (bool, ErrorCodeStatement, ErrorCodeExpres
 			return false, ASSIGN, vP
 		}
 	} else {
+		setErrorDetail(undeclared(assign.name, t))
 		return false, ASSIGN, Variables
 	}
 }
@@ -637,10 +1041,34 @@ func (e Print) check(t TyState) (bool, ErrorCodeStatement, ErrorCodeExpression)
 	return false, PRINT, vPi
 }
 
+func (e Skip) check(t TyState) (bool, ErrorCodeStatement, ErrorCodeExpression) {
+	return true, COMS, 0
+}
+
+// Assert and assume
+
+func (e Assert) check(t TyState) (bool, ErrorCodeStatement, ErrorCodeExpression) {
+	v, _ := e.e.infer(t)
+	if v == TyBool {
+		return true, ASSERT, 0
+	}
+	return false, ASSERT, Condition
+}
+
+func (e Assume) check(t TyState) (bool, ErrorCodeStatement, ErrorCodeExpression) {
+	v, _ := e.e.infer(t)
+	if v == TyBool {
+		return true, ASSUME, 0
+	}
+	return false, ASSUME, Condition
+}
+
 // Block
 
 func (b Block) check(t TyState) (bool, ErrorCodeStatement, ErrorCodeExpression) {
+	declScopes = append(declScopes, map[string]bool{})
 	v, vP, vPi := b.s.check(t)
+	declScopes = declScopes[:len(declScopes)-1]
 	if v {
 		return true, BLOCK, vPi
 	} else {
@@ -652,9 +1080,17 @@ func (b Block) check(t TyState) (bool, ErrorCodeStatement, ErrorCodeExpression)
 
 func (ifel IfEl) check(t TyState) (bool, ErrorCodeStatement, ErrorCodeExpression) {
 	b1, _ := ifel.e.infer(t)
-	b2, b2P, b2Pi := ifel.b1.check(t)
-	b3, b3P, b3Pi := ifel.b2.check(t)
+	t1 := copyTyState(t)
+	t2 := copyTyState(t)
+	b2, b2P, b2Pi := ifel.b1.check(t1)
+	b3, b3P, b3Pi := ifel.b2.check(t2)
 	if b1 == TyBool && b2 && b3 {
+		// ValState is flat, a branch must not change the type of a
+		// variable declared before the if
+		if !keepsTypes(t, t1, "in the then branch") || !keepsTypes(t, t2, "in the else branch") ||
+			!joinTyStates(t, t1, t2, "in the then branch", "in the else branch") {
+			return false, IF, BranchConflict
+		}
 		return true, IF, 0
 	}
 	if b1 != TyBool {
@@ -672,8 +1108,17 @@ func (ifel IfEl) check(t TyState) (bool, ErrorCodeStatement, ErrorCodeExpression
 
 func (w While) check(t TyState) (bool, ErrorCodeStatement, ErrorCodeExpression) {
 	b1, _ := w.e.infer(t)
-	b2, b2P, b2Pi := w.b.check(t)
+	if w.inv != nil {
+		if inv, _ := w.inv.infer(t); inv != TyBool {
+			return false, WHILE, Condition
+		}
+	}
+	t1 := copyTyState(t)
+	b2, b2P, b2Pi := w.b.check(t1)
 	if b1 == TyBool && b2 {
+		if !joinTyStates(t, copyTyState(t), t1, "before the loop", "in the loop body") {
+			return false, WHILE, BranchConflict
+		}
 		return true, WHILE, 0
 	}
 	if b1 != TyBool {
@@ -684,6 +1129,71 @@ func (w While) check(t TyState) (bool, ErrorCodeStatement, ErrorCodeExpression)
 
 }
 
+// Join of the type states of two paths
+
+func copyTyState(t TyState) TyState {
+	t2 := make(TyState)
+	for x, ty := range t {
+		t2[x] = ty
+	}
+	return t2
+}
+
+// After the join t holds every variable declared on both paths with the same
+// type. Variables declared on one path only are dropped, a variable typed
+// differently on the two paths is a conflict and makes the join fail.
+func joinTyStates(t, t1, t2 TyState, path1, path2 string) bool {
+	var names []string
+	for x := range t1 {
+		names = append(names, x)
+	}
+	sort.Strings(names)
+	joined := TyState{}
+	for _, x := range names {
+		ty2, ok := t2[x]
+		if !ok {
+			continue
+		}
+		if t1[x] != ty2 {
+			setErrorDetail("variable " + x + " is " + showType(t1[x]) + " " + path1 + " but " + showType(ty2) + " " + path2)
+			return false
+		}
+		joined[x] = ty2
+	}
+	// Only the names both paths declared are new in the current block
+	for x := range joined {
+		if _, ok := t[x]; !ok {
+			if n := len(declScopes); n > 0 {
+				declScopes[n-1][x] = true
+			}
+		}
+	}
+	for x := range t {
+		delete(t, x)
+	}
+	for x, ty := range joined {
+		t[x] = ty
+	}
+	return true
+}
+
+// True if every variable of t has the same type in t1, the state after a
+// branch
+func keepsTypes(t, t1 TyState, path string) bool {
+	var names []string
+	for x := range t {
+		names = append(names, x)
+	}
+	sort.Strings(names)
+	for _, x := range names {
+		if ty, ok := t1[x]; ok && ty != t[x] {
+			setErrorDetail("variable " + x + " is " + showType(t[x]) + " before the if but " + showType(ty) + " " + path)
+			return false
+		}
+	}
+	return true
+}
+
 // Simple scanner/lexer
 
 // Tokens
@@ -721,6 +1231,19 @@ const (
 	CLOSEC = 30
 	ELSE   = 31
 	BLOCK  = 32
+	MINUS  = 33
+	NEQ    = 34
+	LEQ    = 35
+	GRE    = 36
+	GEQ    = 37
+	COLON  = 38
+	DEFVAR = 39
+	INT       = 40
+	BOOL      = 41
+	ASSERT    = 42
+	ASSUME    = 43
+	INVARIANT = 44
+	SKIP      = 45
 )
 
 func (s State) printToken() string {
@@ -789,6 +1312,32 @@ func (s State) printToken() string {
 		return "CLOSEC"
 	case s.tok == 31:
 		return "ELSE"
+	case s.tok == 33:
+		return "MINUS"
+	case s.tok == 34:
+		return "NEQ"
+	case s.tok == 35:
+		return "LEQ"
+	case s.tok == 36:
+		return "GRE"
+	case s.tok == 37:
+		return "GEQ"
+	case s.tok == 38:
+		return "COLON"
+	case s.tok == 39:
+		return "DEFVAR"
+	case s.tok == 40:
+		return "INT"
+	case s.tok == 41:
+		return "BOOL"
+	case s.tok == 42:
+		return "ASSERT"
+	case s.tok == 43:
+		return "ASSUME"
+	case s.tok == 44:
+		return "INVARIANT"
+	case s.tok == 45:
+		return "SKIP"
 
 	}
 	return "Not a Token"
@@ -862,6 +1411,32 @@ func printToken(i ErrorCodeStatement) string {
 		return "ELSE"
 	case i == 32:
 		return "BLOCK"
+	case i == 33:
+		return "MINUS"
+	case i == 34:
+		return "NEQ"
+	case i == 35:
+		return "LEQ"
+	case i == 36:
+		return "GRE"
+	case i == 37:
+		return "GEQ"
+	case i == 38:
+		return "COLON"
+	case i == 39:
+		return "DEFVAR"
+	case i == 40:
+		return "INT"
+	case i == 41:
+		return "BOOL"
+	case i == 42:
+		return "ASSERT"
+	case i == 43:
+		return "ASSUME"
+	case i == 44:
+		return "INVARIANT"
+	case i == 45:
+		return "SKIP"
 	}
 	return "Not a Token"
 }
@@ -892,6 +1467,22 @@ func printExp(i ErrorCodeExpression) string {
 		return "Condition IllTyped"
 	case i == 12:
 		return "Error in Block "
+	case i == 13:
+		return "IllTyped Subtraction"
+	case i == 14:
+		return "IllTyped Inequality"
+	case i == 15:
+		return "IllTyped LesserEqual"
+	case i == 16:
+		return "IllTyped Greater"
+	case i == 17:
+		return "IllTyped GreaterEqual"
+	case i == 18:
+		return "Type annotation mismatch"
+	case i == 19:
+		return "Variable already declared"
+	case i == 20:
+		return "Variable typed differently on branches"
 	default:
 		return "Undefined"
 	}
@@ -924,6 +1515,8 @@ func scan(s string) (string, int) {
 			return s[1:len(s)], NINE
 		case s[0] == '+':
 			return s[1:len(s)], PLUS
+		case s[0] == '-':
+			return s[1:len(s)], MINUS
 		case s[0] == '*':
 			return s[1:len(s)], MULT
 		case s[0] == '(':
@@ -934,8 +1527,16 @@ func scan(s string) (string, int) {
 			return s[1:len(s)], OPENC
 		case s[0] == '}':
 			return s[1:len(s)], CLOSEC
+		case len(s) >= 2 && s[0] == '<' && s[1] == '=':
+			return s[2:len(s)], LEQ
 		case s[0] == '<':
 			return s[1:len(s)], LESS
+		case len(s) >= 2 && s[0] == '>' && s[1] == '=':
+			return s[2:len(s)], GEQ
+		case s[0] == '>':
+			return s[1:len(s)], GRE
+		case len(s) >= 2 && s[0] == '!' && s[1] == '=':
+			return s[2:len(s)], NEQ
 		case s[0] == '!':
 			return s[1:len(s)], NEG
 		case len(s) >= 2 && s[0] == '=' && s[1] == '=':
@@ -944,13 +1545,15 @@ func scan(s string) (string, int) {
 			return s[1:len(s)], ASSIGN
 		case len(s) >= 2 && s[0] == ':' && s[1] == '=':
 			return s[2:len(s)], DECL
+		case s[0] == ':':
+			return s[1:len(s)], COLON
 		case len(s) >= 2 && s[0] == '|' && s[1] == '|':
 			return s[2:len(s)], OR
 		case len(s) >= 2 && s[0] == '&' && s[1] == '&':
 			return s[2:len(s)], AND
 		case s[0] == ';':
 			return s[1:len(s)], COMS
-		case len(s) >= 2 && unicode.IsLetter(rune(s[0])):
+		case unicode.IsLetter(rune(s[0])):
 			i := 0
 			for len(s) >= i+1 && unicode.IsLetter(rune(s[0+i])) {
 				i++
@@ -968,6 +1571,20 @@ func scan(s string) (string, int) {
 				return s[i:len(s)], TRUE
 			case s[0:i] == "false":
 				return s[i:len(s)], FALSE
+			case s[0:i] == "var":
+				return s[i:len(s)], DEFVAR
+			case s[0:i] == "int":
+				return s[i:len(s)], INT
+			case s[0:i] == "bool":
+				return s[i:len(s)], BOOL
+			case s[0:i] == "assert":
+				return s[i:len(s)], ASSERT
+			case s[0:i] == "assume":
+				return s[i:len(s)], ASSUME
+			case s[0:i] == "invariant":
+				return s[i:len(s)], INVARIANT
+			case s[0:i] == "skip":
+				return s[i:len(s)], SKIP
 			default:
 				varName = s[0:i]
 				return s[i:len(s)], VAR
@@ -980,17 +1597,142 @@ func scan(s string) (string, int) {
 }
 
 type State struct {
-	s   *string
-	tok int
+	s     *string
+	tok   int
+	start int // offset of the current token
+	last  int // offset after the last consumed token
 }
 
 func next(s *State) {
-	s2, tok := scan(*s.s)
+	s.last = inputLength - len(*s.s)
+	rest := strings.TrimLeft(*s.s, " \t\r\n")
+	s.start = inputLength - len(rest)
+	s2, tok := scan(rest)
 
 	s.s = &s2
 	s.tok = tok
 }
 
+// Line and column of an offset in the parsed input
+func posAt(offset int) (int, int) {
+	line, col := 1, 1
+	for i := 0; i < offset && i < len(inputSource); i++ {
+		if inputSource[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// Span from offset start to the end of the last consumed token
+func spanFrom(s *State, start int) Span {
+	line, col := posAt(start)
+	endLine, endCol := posAt(s.last)
+	return Span{line, col, endLine, endCol}
+}
+
+// Spans of the nodes of an expression, kids in the order of expChildren
+type spanTree struct {
+	span Span
+	kids []*spanTree
+}
+
+// The expression parser pushes the spans of the nodes it builds, a node pops
+// those of its children. Statements keep the trees of their expressions in
+// expSpans, in the order the expressions appear in the source.
+var expSpanStack []*spanTree
+var expSpans map[Span][]*spanTree
+
+// Span of a node whose first token started at offset start and that has
+// n children on the stack
+func pushExp(s *State, start int, n int) {
+	t := &spanTree{}
+	// A negative start marks a placeholder, no token was consumed
+	if start >= 0 {
+		t.span = spanFrom(s, start)
+	}
+	t.kids = popExps(n)
+	expSpanStack = append(expSpanStack, t)
+}
+
+// Span of a binary node, from its first operand to the last token
+func pushBinary(s *State) {
+	kids := popExps(2)
+	first := kids[0].span
+	if first.line == 0 {
+		first = kids[1].span
+	}
+	endLine, endCol := posAt(s.last)
+	t := &spanTree{Span{first.line, first.col, endLine, endCol}, kids}
+	expSpanStack = append(expSpanStack, t)
+}
+
+func popExps(n int) []*spanTree {
+	if len(expSpanStack) < n {
+		return nil
+	}
+	k := len(expSpanStack) - n
+	kids := append([]*spanTree{}, expSpanStack[k:]...)
+	expSpanStack = expSpanStack[:k]
+	return kids
+}
+
+func popExp() *spanTree {
+	if ts := popExps(1); ts != nil {
+		return ts[0]
+	}
+	return nil
+}
+
+// Keeps the trees of the last n expressions for the statement at sp
+func keepExpSpans(sp Span, n int) Span {
+	ts := popExps(n)
+	if expSpans != nil {
+		expSpans[sp] = ts
+	}
+	return sp
+}
+
+// Span of a statement, a sequence spans from the start of its first to the
+// end of its last statement
+func stmtSpan(s Stmt) Span {
+	switch s := s.(type) {
+	case ComS:
+		first, last := stmtSpan(s[0]), stmtSpan(s[1])
+		if first.line == 0 || last.line == 0 {
+			return Span{}
+		}
+		return Span{first.line, first.col, last.endLine, last.endCol}
+	case Decl:
+		return s.span
+	case Assign:
+		return s.span
+	case While:
+		return s.span
+	case IfEl:
+		return s.span
+	case Print:
+		return s.span
+	case Assert:
+		return s.span
+	case Assume:
+		return s.span
+	case Skip:
+		return s.span
+	}
+	return Span{}
+}
+
+func (sp Span) pretty() string {
+	if sp.line == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d-%d:%d", sp.line, sp.col, sp.endLine, sp.endCol)
+}
+
 // Block ::= { CmdS }
 func parseBlock(s *State) (bool, Block) {
 
@@ -998,6 +1740,7 @@ func parseBlock(s *State) (bool, Block) {
 
 		return false, Block{}
 	}
+	start := s.start
 
 	b, t := parseComS(s)
 	if !b {
@@ -1009,7 +1752,7 @@ func parseBlock(s *State) (bool, Block) {
 	}
 	next(s)
 
-	return true, Block{t}
+	return true, Block{t, spanFrom(s, start)}
 }
 
 // CmdS ::= Stmt CmdS2
@@ -1037,11 +1780,38 @@ func parseComS2(s *State, e Stmt) (bool, Stmt) {
 	return true, e
 }
 
-// Stmt ::= ASS | DECL | IFEL | WHILE | PRINT
+// Ty ::= int | bool
+func parseType(s *State) (bool, Type) {
+	switch s.tok {
+	case INT:
+		next(s)
+		return true, TyInt
+	case BOOL:
+		next(s)
+		return true, TyBool
+	}
+	return false, TyIllTyped
+}
+
+// Stmt ::= ASS | DECL | IFEL | WHILE | PRINT | ASSERT | ASSUME | SKIP
 func parseStatement(s *State) (bool, Stmt) {
 	next(s)
+	start := s.start
 
 	switch {
+	case s.tok == DEFVAR:
+		next(s)
+		if s.tok != VAR {
+			return false, Decl{}
+		}
+		name := varName
+		next(s)
+		b, ty := parseType(s)
+		if !b {
+			return false, Decl{}
+		}
+		return true, Decl{name, zeroExp(ty), ty, true, spanFrom(s, start)}
+
 	case s.tok == VAR:
 		next(s)
 		name := varName
@@ -1053,14 +1823,26 @@ func parseStatement(s *State) (bool, Stmt) {
 			if !b {
 				return false, Decl{}
 			}
-			return true, Decl{name, e}
+			return true, Decl{name, e, TyIllTyped, false, keepExpSpans(spanFrom(s, start), 1)}
+		case s.tok == COLON:
+			next(s)
+			b, ty := parseType(s)
+			if !b || s.tok != DECL {
+				return false, Decl{}
+			}
+			next(s)
+			b, e := parseOr(s)
+			if !b {
+				return false, Decl{}
+			}
+			return true, Decl{name, e, ty, false, keepExpSpans(spanFrom(s, start), 1)}
 		case s.tok == ASSIGN:
 			next(s)
 			b, e := parseOr(s)
 			if !b {
 				return false, Assign{}
 			}
-			return true, Assign{name, e}
+			return true, Assign{name, e, keepExpSpans(spanFrom(s, start), 1)}
 		}
 		return false, nil
 
@@ -1071,13 +1853,29 @@ func parseStatement(s *State) (bool, Stmt) {
 		if !b {
 			return false, While{}
 		}
+		// While ::= while Or [invariant Or] Block
+		var inv Exp
+		if s.tok == INVARIANT {
+			invStart := s.start
+			next(s)
+			b, inv = parseOr(s)
+			if !b {
+				return false, While{}
+			}
+			// The Invariant node spans the keyword and the expression
+			pushExp(s, invStart, 1)
+		}
 
 		b, bl := parseBlock(s)
 
 		if !b {
 			return false, While{}
 		}
-		return true, While{e, bl}
+		n := 1
+		if inv != nil {
+			n = 2
+		}
+		return true, While{e, bl, inv, keepExpSpans(spanFrom(s, start), n)}
 
 	case s.tok == IF:
 		next(s)
@@ -1101,14 +1899,29 @@ func parseStatement(s *State) (bool, Stmt) {
 		if !b {
 			return false, IfEl{}
 		}
-		return true, IfEl{e, bl, bl2}
+		return true, IfEl{e, bl, bl2, keepExpSpans(spanFrom(s, start), 1)}
 	case s.tok == PRINT:
 		next(s)
 		b, e := parseOr(s)
 		if !b {
 			return false, Print{}
 		}
-		return true, Print{e}
+		return true, Print{e, keepExpSpans(spanFrom(s, start), 1)}
+	case s.tok == ASSERT || s.tok == ASSUME:
+		tok := s.tok
+		next(s)
+		b, e := parseOr(s)
+		if !b {
+			return false, nil
+		}
+		sp := keepExpSpans(spanFrom(s, start), 1)
+		if tok == ASSERT {
+			return true, Assert{e, sp}
+		}
+		return true, Assume{e, sp}
+	case s.tok == SKIP:
+		next(s)
+		return true, Skip{spanFrom(s, start)}
 	default:
 		return false, nil
 	}
@@ -1133,6 +1946,7 @@ func parseOr2(s *State, e Exp) (bool, Exp) {
 			return false, e
 		}
 		t := (Or)([2]Exp{e, f})
+		pushBinary(s)
 		return parseOr2(s, t)
 	}
 
@@ -1157,6 +1971,7 @@ func parseAnd2(s *State, e Exp) (bool, Exp) {
 			return false, e
 		}
 		t := (And)([2]Exp{e, f})
+		pushBinary(s)
 		return parseAnd2(s, t)
 	}
 
@@ -1172,7 +1987,7 @@ func parseEqu(s *State) (bool, Exp) {
 	return parseEqu2(s, e)
 }
 
-// EQU2 ::= == T EQU2
+// EQU2 ::= == T EQU2 | != T EQU2
 func parseEqu2(s *State, e Exp) (bool, Exp) {
 	if s.tok == EQU {
 		next(s)
@@ -1181,6 +1996,17 @@ func parseEqu2(s *State, e Exp) (bool, Exp) {
 			return false, e
 		}
 		t := (Equ)([2]Exp{e, f})
+		pushBinary(s)
+		return parseEqu2(s, t)
+	}
+	if s.tok == NEQ {
+		next(s)
+		b, f := parseNeg(s)
+		if !b {
+			return false, e
+		}
+		t := (Neq)([2]Exp{e, f})
+		pushBinary(s)
 		return parseEqu2(s, t)
 	}
 
@@ -1199,12 +2025,18 @@ func parseNeg(s *State) (bool, Exp) {
 // Neg2 ::= == Or Neg2
 func parseNeg2(s *State, e Exp) (bool, Exp) {
 	if s.tok == NEG {
+		start := s.start
 		next(s)
 		b, f := parseL(s)
 		if !b {
 			return false, e
 		}
 		t := (Neg)([1]Exp{f})
+		// e is dropped, it is a placeholder from parseF
+		kid := popExp()
+		popExp()
+		expSpanStack = append(expSpanStack, kid)
+		pushExp(s, start, 1)
 		return parseNeg2(s, t)
 	}
 
@@ -1220,15 +2052,28 @@ func parseL(s *State) (bool, Exp) {
 	return parseL2(s, e)
 }
 
-// L2 ::= < T L2
+// L2 ::= < T L2 | <= T L2 | > T L2 | >= T L2
 func parseL2(s *State, e Exp) (bool, Exp) {
-	if s.tok == LESS {
+	switch s.tok {
+	case LESS, LEQ, GRE, GEQ:
+		op := s.tok
 		next(s)
 		b, f := parseE(s)
 		if !b {
 			return false, e
 		}
-		t := (Les)([2]Exp{e, f})
+		var t Exp
+		switch op {
+		case LESS:
+			t = (Les)([2]Exp{e, f})
+		case LEQ:
+			t = (Leq)([2]Exp{e, f})
+		case GRE:
+			t = (Gre)([2]Exp{e, f})
+		default:
+			t = (Geq)([2]Exp{e, f})
+		}
+		pushBinary(s)
 		return parseL2(s, t)
 	}
 
@@ -1244,7 +2089,7 @@ func parseE(s *State) (bool, Exp) {
 	return parseE2(s, e)
 }
 
-// E2 ::= + T E2 |
+// E2 ::= + T E2 | - T E2 |
 func parseE2(s *State, e Exp) (bool, Exp) {
 	if s.tok == PLUS {
 		next(s)
@@ -1253,6 +2098,17 @@ func parseE2(s *State, e Exp) (bool, Exp) {
 			return false, e
 		}
 		t := (Plus)([2]Exp{e, f})
+		pushBinary(s)
+		return parseE2(s, t)
+	}
+	if s.tok == MINUS {
+		next(s)
+		b, f := parseT(s)
+		if !b {
+			return false, e
+		}
+		t := (Minus)([2]Exp{e, f})
+		pushBinary(s)
 		return parseE2(s, t)
 	}
 
@@ -1277,6 +2133,7 @@ func parseT2(s *State, e Exp) (bool, Exp) {
 			return false, e
 		}
 		t := (Mult)([2]Exp{e, f})
+		pushBinary(s)
 		return parseT2(s, t)
 	}
 	return true, e
@@ -1284,42 +2141,55 @@ func parseT2(s *State, e Exp) (bool, Exp) {
 
 // F ::= N | (E)
 func parseF(s *State) (bool, Exp) {
+	start := s.start
 	switch {
 	case s.tok == ZERO:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Num)(0)
 	case s.tok == ONE:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Num)(1)
 	case s.tok == TWO:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Num)(2)
 	case s.tok == THREE:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Num)(3)
 	case s.tok == FOUR:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Num)(4)
 	case s.tok == FIVE:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Num)(5)
 	case s.tok == SIX:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Num)(6)
 	case s.tok == SEVEN:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Num)(7)
 	case s.tok == EIGHT:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Num)(8)
 	case s.tok == NINE:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Num)(9)
 	case s.tok == TRUE:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Bool)(true)
 	case s.tok == FALSE:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Bool)(false)
 	case s.tok == OPEN:
 		next(s)
@@ -1331,27 +2201,41 @@ func parseF(s *State) (bool, Exp) {
 			return false, e
 		}
 		next(s)
+		// The span of a parenthesized expression includes the parentheses
+		if len(expSpanStack) > 0 {
+			expSpanStack[len(expSpanStack)-1].span = spanFrom(s, start)
+		}
 		return true, e
 	case s.tok == NEG:
+		pushExp(s, -1, 0)
 		return true, (Num)(0)
 	case s.tok == VAR:
 		next(s)
+		pushExp(s, start, 0)
 		return true, (Var)(varName)
 	case s.tok == WHILE:
+		pushExp(s, -1, 0)
 		return true, (Num)(0)
 	case s.tok == OPENC:
+		pushExp(s, -1, 0)
 		return true, (Num)(0)
 	case s.tok == CLOSEC:
+		pushExp(s, -1, 0)
 		return true, (Num)(0)
 	case s.tok == IF:
+		pushExp(s, -1, 0)
 		return true, (Num)(0)
 	case s.tok == ELSE:
+		pushExp(s, -1, 0)
 		return true, (Num)(0)
 	case s.tok == DECL:
+		pushExp(s, -1, 0)
 		return true, (Num)(0)
 	case s.tok == ASSIGN:
+		pushExp(s, -1, 0)
 		return true, (Num)(0)
 	case s.tok == PRINT:
+		pushExp(s, -1, 0)
 		return true, (Num)(0)
 	}
 
@@ -1359,8 +2243,11 @@ func parseF(s *State) (bool, Exp) {
 }
 
 func parse(s string) (bool, int, Block) {
-	st := State{&s, EOS}
+	st := State{s: &s, tok: EOS}
 	inputLength = len(s)
+	inputSource = s
+	expSpanStack = nil
+	expSpans = map[Span][]*spanTree{}
 	next(&st)
 	b, e := parseBlock(&st)
 	if st.tok == EOS && b == true {
@@ -1371,12 +2258,36 @@ func parse(s string) (bool, int, Block) {
 	return false, errorAt, Block{} // dummy value
 }
 
+// Or on its own, used by the debugger to evaluate expressions
+func parseExpression(s string) (bool, int, Exp) {
+	st := State{s: &s, tok: EOS}
+	inputLength = len(s)
+	inputSource = s
+	expSpanStack = nil
+	next(&st)
+	b, e := parseOr(&st)
+	if st.tok == EOS && b {
+		return true, 0, e
+	}
+	errorLength = len(*st.s)
+	return false, inputLength - errorLength, nil
+}
+
+func illTypedMessage(errorIn ErrorCodeStatement, errorAtExp ErrorCodeExpression) string {
+	msg := "Illtyped Statement found, StatementType = " + printToken(errorIn) + ", Reason = " + printExp(errorAtExp)
+	if errorDetail != "" {
+		msg += " (" + errorDetail + ")"
+	}
+	return msg
+}
+
 func debug(s string) {
 	fmt.Printf("%s", s)
 }
 
 func test(s string) {
 	stmt, errorAtStmt, e := parse(s)
+	errorDetail = ""
 	var vals = make(ValState)
 	var types = make(TyState)
 	fmt.Printf("\n Input: %s", s)
@@ -1386,15 +2297,26 @@ func test(s string) {
 	}
 	fmt.Printf("\n Output Parse: %s", e.pretty())
 
+	if errs := definiteAssignment(e); len(errs) > 0 {
+		fmt.Printf("\n Definite Assignment: false ")
+		for _, err := range errs {
+			fmt.Printf("\n %s", err)
+		}
+		fmt.Printf("\n")
+		return
+	}
+
 	exp, errorIn, errorAtExp := e.check(types)
 	fmt.Printf("\n Check: %t ", exp)
 	if !exp {
 		fmt.Printf("\n ERROR ON EVALUATION \n")
-		fmt.Printf(" Illtyped Statement found, StatementType = " + printToken(errorIn) + ", Reason = " + printExp(errorAtExp) + "\n")
+		fmt.Printf(" %s\n", illTypedMessage(errorIn, errorAtExp))
 		return
 	}
 	fmt.Printf("\n Evalutaion: ")
-	e.eval(vals)
+	if err := evalChecked(e, vals); err != nil {
+		fmt.Printf("\n %s", err)
+	}
 	fmt.Printf("\n")
 }
 
@@ -1509,6 +2431,66 @@ func testParserGood() {
 		"};" +
 		"print true" +
 		"}")
+
+	fmt.Printf("\n Test 16.1 - Minus - print varX - varY \n")
+	test("{varX:=3;varY:=4;print varX-varY}")
+	test("{varX:=9;print varX-3-2}")
+	fmt.Printf("\n Test 16.2 - False Minus - bool - int\n")
+	test("{varX:=true;varY:=4;print varX-varY}")
+
+	fmt.Printf("\n Test 17.1 - Inequality - print varX != varY \n")
+	test("{varX:=1;varY:=2;print varX!=varY}")
+	test("{varX:=true;varY:=true;print varX!=varY}")
+	fmt.Printf("\n Test 17.2 - False Inequality - bool != int\n")
+	test("{varX:=true;varY:=4;print varX!=varY}")
+
+	fmt.Printf("\n Test 18.1 - Comparison - print varX <= varY, varX > varY, varX >= varY \n")
+	test("{varX:=3;varY:=3;print varX<=varY;print varX>varY;print varX>=varY}")
+	test("{varX:=4;varY:=3;print varX<=varY;print varX>varY;print varX>=varY}")
+	fmt.Printf("\n Test 18.2 - Comparison - precedence of < <= > >= below + - and above == \n")
+	test("{varX:=5;print varX-1>=2+2 == 1<varX}")
+	fmt.Printf("\n Test 18.3 - False Comparison - bool > int\n")
+	test("{varX:=true;varY:=4;print varX>varY}")
+
+	fmt.Printf("\n Test 19.1 - Annotated Declaration - varX : int := 3 \n")
+	test("{varX : int := 3; varY : bool := varX < 4; print varX; print varY}")
+	fmt.Printf("\n Test 19.2 - Zero Initialized Declaration - var varX int \n")
+	test("{var varX int; var varY bool; print varX; print varY; varX = varX + 2; print varX}")
+	fmt.Printf("\n Test 19.3 - False Annotated Declaration - int := bool \n")
+	test("{varX : int := true}")
+
+	fmt.Printf("\n Test 20.1 - False Redeclaration - varX := 1; varX := true \n")
+	test("{varX:=1; varX:=true; print varX}")
+	fmt.Printf("\n Test 20.2 - Declaration in inner Block - while varX { varY := 1 } \n")
+	test("{varX:=false; while varX {varY:=1}; varY:=2; print varY}")
+	fmt.Printf("\n Test 20.3 - False Assignment - not declarated, did you mean \n")
+	test("{varX:=1; varZ = 2}")
+	test("{count:=1; print conut + 1}")
+	fmt.Printf("\n Test 20.4 - False Assignment - the detail of the first error is kept \n")
+	test("{varX:=1; varX:=2; varY = 3}")
+	test("{varH := 0; while varH < 5 {if true {var varD bool} else {varD := 0}; varH = varH+1; varE = 1}}")
+
+	fmt.Printf("\n Test 21.1 - Branch Join - declared with the same type in both branches \n")
+	test("{varX:=true; if varX {varY:=1} else {varY:=2}; print varY}")
+	fmt.Printf("\n Test 21.2 - False Branch Join - declared in one branch only \n")
+	test("{varX:=true; if varX {varY:=1} else {print varX}; print varY}")
+	fmt.Printf("\n Test 21.3 - False Branch Join - declared with different types \n")
+	test("{varX:=true; if varX {varY:=1} else {varY:=false}; print varX}")
+	fmt.Printf("\n Test 21.4 - False Branch Join - redeclared with a different type in a loop \n")
+	test("{varX:=false; while varX {varX:=1}; print varX}")
+	fmt.Printf("\n Test 21.5 - False Branch Join - redeclared with a different type in both branches \n")
+	test("{varX:=1; if true {varX:=true} else {varX:=false}; print varX}")
+	fmt.Printf("\n Test 21.6 - Branch Join - variables declared before an if or while stay redeclarable in an inner block \n")
+	test("{varX:=1; while false {if true {print 1} else {print 2}; varX := 2}; print varX}")
+	test("{varA := 1; while varA < 3 {while false {print 1}; varA := 5}; print varA}")
+	fmt.Printf("\n Test 21.7 - False Branch Join - the variables before the if are kept after a conflict \n")
+	test("{varZ:=1; if true {varY:=1} else {varY:=true}; varZ = 2}")
+
+	fmt.Printf("\n Test 22.1 - Definite Assignment - declared on all paths \n")
+	test("{varX:=1; if varX<2 {varY:=1} else {if varX<3 {varY:=2} else {varY:=3}}; print varY}")
+	fmt.Printf("\n Test 22.2 - False Definite Assignment - declared on some paths \n")
+	test("{varX:=1; if varX<2 {varY:=1} else {if varX<3 {varY:=2} else {print varX}}; print varY}")
+	test("{varX:=1; while varX<3 {varY:=varX; varX = varX+1}; varX = varY}")
 }
 
 // Helper functions to build ASTs by hand
@@ -1558,6 +2540,36 @@ func les(x, y Exp) Exp {
 	return (Les)([2]Exp{x, y})
 }
 
+// Subtraction
+
+func minus(x, y Exp) Exp {
+	return (Minus)([2]Exp{x, y})
+}
+
+// Inequality Test
+
+func neq(x, y Exp) Exp {
+	return (Neq)([2]Exp{x, y})
+}
+
+// Lesser Equal Test
+
+func leq(x, y Exp) Exp {
+	return (Leq)([2]Exp{x, y})
+}
+
+// Greater Test
+
+func gre(x, y Exp) Exp {
+	return (Gre)([2]Exp{x, y})
+}
+
+// Greater Equal Test
+
+func geq(x, y Exp) Exp {
+	return (Geq)([2]Exp{x, y})
+}
+
 // Vars
 
 // Command Sequence
@@ -1567,32 +2579,37 @@ func cs(x, y Stmt) Stmt {
 
 // Variable declaration
 func decl(x string, y Exp) Decl {
-	return Decl{x, y}
+	return Decl{x, y, TyIllTyped, false, Span{}}
+}
+
+// Variable declaration with type annotation
+func typedDecl(x string, t Type, y Exp) Decl {
+	return Decl{x, y, t, false, Span{}}
 }
 
 // Variable assignment
 func assign(x string, y Exp) Assign {
-	return Assign{x, y}
+	return Assign{x, y, Span{}}
 }
 
 // While
 func while(e Exp, b Block) While {
-	return While{e, b}
+	return While{e, b, nil, Span{}}
 }
 
 // If-then-else
 func ifel(e Exp, b1 Block, b2 Block) IfEl {
-	return IfEl{e, b1, b2}
+	return IfEl{e, b1, b2, Span{}}
 }
 
 // Print
 func print(e Exp) Print {
-	return Print{e}
+	return Print{e, Span{}}
 }
 
 // Block
 func block(s Stmt) Block {
-	return Block{s}
+	return Block{s, Span{}}
 }
 func examplesAST() {
 	ast1 := block(cs(cs(cs(decl("trudy", number(3)), print(variable("trudy"))), cs(assign("trudy", plus(variable("trudy"), number(3))), print(variable("trudy")))), while(les(variable("trudy"), number(13)), block(ifel(les(variable("trudy"), number(11)), block(cs(print(variable("trudy")), assign("trudy", plus(variable("trudy"), number(1))))), block(assign("trudy", plus(variable("trudy"), number(1)))))))))
@@ -1604,8 +2621,31 @@ func examplesAST() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 	examplesAST()
 
 	fmt.Printf("\n")
 	testParserGood()
+	testOptimizer()
+	testLint()
+	testCFG()
+	testAST()
+	testSerialize()
+	testCBackend()
+	testGoBackend()
+	testWasmBackend()
+	testAmd64Backend()
+	testLLVMBackend()
+	testJSBackend()
+	testIR()
+	testIROptimizer()
+	testIntervals()
+	testVerify()
+	testRuntimeAssert()
+	testSymex()
+	testTermination()
+	testDebug()
+	testDAP()
 }
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// x86-64 backend
+//
+// Translates a checked Block into GNU assembler (AT&T syntax) for Linux. The
+// program is a main function linked against libc, print calls printf (ints)
+// or puts (bools). Every variable has a stack slot below %rbp. Temporaries of
+// expressions live in caller-saved registers, if these run out the left
+// operand of a binary expression is pushed and popped into %rax. A failing
+// assert writes its message to stderr with dprintf and calls exit(1).
+
+// Registers for temporaries, main does not have to preserve them
+var amd64Regs = []string{"%rcx", "%rdx", "%rsi", "%rdi", "%r8", "%r9", "%r10", "%r11"}
+
+type amd64Gen struct {
+	buf     strings.Builder
+	slot    map[typedVar]int
+	free    []string
+	labels  int
+	asserts []string // messages, .Lassert<n> is the n-th
+}
+
+func genAmd64(b Block) string {
+	g := &amd64Gen{slot: map[typedVar]int{}}
+	g.free = append(g.free, amd64Regs...)
+	vars := declaredVars(b)
+	for i, v := range vars {
+		g.slot[v] = -8 * (i + 1)
+	}
+	// The stack stays 16 byte aligned for calls
+	frame := (8*len(vars) + 15) / 16 * 16
+
+	g.buf.WriteString("\t.section .rodata\n")
+	g.buf.WriteString(".Lfmt_int:\n\t.string \"%ld\\n\"\n")
+	g.buf.WriteString(".Ltrue:\n\t.string \"true\"\n")
+	g.buf.WriteString(".Lfalse:\n\t.string \"false\"\n")
+	g.buf.WriteString("\n\t.text\n\t.globl main\n\t.type main, @function\nmain:\n")
+	g.line("pushq %%rbp")
+	g.line("movq %%rsp, %%rbp")
+	if frame > 0 {
+		g.line("subq $%d, %%rsp", frame)
+	}
+	g.stmt(b.s, make(TyState))
+	g.line("xorl %%eax, %%eax")
+	g.line("leave")
+	g.line("ret")
+	g.buf.WriteString("\t.size main, .-main\n")
+	if len(g.asserts) > 0 {
+		g.buf.WriteString("\n\t.section .rodata\n")
+		for i, msg := range g.asserts {
+			fmt.Fprintf(&g.buf, ".Lassert%d:\n\t.string %s\n", i+1, strconv.Quote(msg+"\n"))
+		}
+	}
+	g.buf.WriteString("\t.section .note.GNU-stack,\"\",@progbits\n")
+	return g.buf.String()
+}
+
+func (g *amd64Gen) line(format string, args ...any) {
+	g.buf.WriteString("\t")
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteString("\n")
+}
+
+func (g *amd64Gen) label() int {
+	g.labels++
+	return g.labels
+}
+
+// Register allocation
+
+func (g *amd64Gen) alloc() string {
+	r := g.free[0]
+	g.free = g.free[1:]
+	return r
+}
+
+func (g *amd64Gen) release(r string) {
+	g.free = append([]string{r}, g.free...)
+}
+
+func (g *amd64Gen) slotOf(x string, ty Type) string {
+	return fmt.Sprintf("%d(%%rbp)", g.slot[typedVar{x, ty}])
+}
+
+func (g *amd64Gen) stmt(s Stmt, t TyState) {
+	switch s := s.(type) {
+	case ComS:
+		g.stmt(s[0], t)
+		g.stmt(s[1], t)
+	case Decl:
+		r := g.exp(s.rhs, t)
+		g.line("movq %s, %s", r, g.slotOf(s.lhs, declare(s, t)))
+		g.release(r)
+	case Assign:
+		r := g.exp(s.value, t)
+		g.line("movq %s, %s", r, g.slotOf(s.name, t[s.name]))
+		g.release(r)
+	case Print:
+		r := g.exp(s.e, t)
+		if ty, _ := s.e.infer(t); ty == TyBool {
+			// lea keeps the flags, r may be %rdi
+			g.line("testq %s, %s", r, r)
+			g.line("leaq .Lfalse(%%rip), %%rdi")
+			g.line("leaq .Ltrue(%%rip), %%rax")
+			g.line("cmovneq %%rax, %%rdi")
+			g.line("call puts@PLT")
+		} else {
+			g.line("movq %s, %%rsi", r)
+			g.line("leaq .Lfmt_int(%%rip), %%rdi")
+			g.line("xorl %%eax, %%eax")
+			g.line("call printf@PLT")
+		}
+		g.release(r)
+	case Assert:
+		n := g.label()
+		g.asserts = append(g.asserts, assertMessage(s))
+		r := g.exp(s.e, t)
+		g.line("testq %s, %s", r, r)
+		g.release(r)
+		g.line("jne .Lok%d", n)
+		g.line("movl $2, %%edi")
+		g.line("leaq .Lassert%d(%%rip), %%rsi", len(g.asserts))
+		g.line("xorl %%eax, %%eax")
+		g.line("call dprintf@PLT")
+		g.line("movl $1, %%edi")
+		g.line("call exit@PLT")
+		g.buf.WriteString(fmt.Sprintf(".Lok%d:\n", n))
+	case IfEl:
+		n := g.label()
+		t1, t2 := copyTyState(t), copyTyState(t)
+		r := g.exp(s.e, t)
+		g.line("testq %s, %s", r, r)
+		g.release(r)
+		g.line("je .Lelse%d", n)
+		g.stmt(s.b1.s, t1)
+		g.line("jmp .Lend%d", n)
+		g.buf.WriteString(fmt.Sprintf(".Lelse%d:\n", n))
+		g.stmt(s.b2.s, t2)
+		g.buf.WriteString(fmt.Sprintf(".Lend%d:\n", n))
+		joinTyStates(t, t1, t2, "", "")
+	case While:
+		n := g.label()
+		g.buf.WriteString(fmt.Sprintf(".Lloop%d:\n", n))
+		r := g.exp(s.e, t)
+		g.line("testq %s, %s", r, r)
+		g.release(r)
+		g.line("je .Ldone%d", n)
+		g.stmt(s.b.s, copyTyState(t))
+		g.line("jmp .Lloop%d", n)
+		g.buf.WriteString(fmt.Sprintf(".Ldone%d:\n", n))
+	}
+}
+
+// Condition codes of the comparisons, for setcc
+var amd64Cond = map[string]string{
+	"Equ": "e", "Neq": "ne", "Les": "l", "Leq": "le", "Gre": "g", "Geq": "ge",
+}
+
+// Evaluates e into a register taken from the free registers
+func (g *amd64Gen) exp(e Exp, t TyState) string {
+	switch e := e.(type) {
+	case Num:
+		r := g.alloc()
+		if int(e) == int(int32(e)) {
+			g.line("movq $%d, %s", int(e), r)
+		} else {
+			g.line("movabsq $%d, %s", int(e), r)
+		}
+		return r
+	case Bool:
+		r := g.alloc()
+		if e {
+			g.line("movq $1, %s", r)
+		} else {
+			g.line("movq $0, %s", r)
+		}
+		return r
+	case Var:
+		r := g.alloc()
+		g.line("movq %s, %s", g.slotOf(string(e), t[string(e)]), r)
+		return r
+	case Neg:
+		r := g.exp(e[0], t)
+		g.line("xorq $1, %s", r)
+		return r
+	}
+	c := expChildren(e)
+	l := g.exp(c[0], t)
+	spilled := len(g.free) == 0
+	if spilled {
+		g.line("pushq %s", l)
+		g.release(l)
+	}
+	r := g.exp(c[1], t)
+	if spilled {
+		l = "%rax"
+		g.line("popq %s", l)
+	}
+	k := kindOf(e)
+	switch k {
+	case "Plus":
+		g.line("addq %s, %s", r, l)
+	case "Minus":
+		g.line("subq %s, %s", r, l)
+	case "Mult":
+		g.line("imulq %s, %s", r, l)
+	case "And":
+		g.line("andq %s, %s", r, l)
+	case "Or":
+		g.line("orq %s, %s", r, l)
+	default:
+		g.line("cmpq %s, %s", r, l)
+		g.line("set%s %%al", amd64Cond[k])
+		g.line("movzbq %%al, %s", l)
+	}
+	if spilled {
+		g.line("movq %%rax, %s", r)
+		return r
+	}
+	g.release(r)
+	return l
+}
+
+func runAmd64(b Block) (string, error) {
+	return runInTempDir(map[string]string{"prog.s": genAmd64(b)},
+		[]string{"cc", "-o", "prog", "prog.s"},
+		[]string{"./prog"})
+}
+
+func testAmd64Backend() {
+	fmt.Printf("\n Test 31.1 - x86-64 Backend - generated code \n")
+	src := "{varX:=1;while varX<3 {print varX<2; varX = varX+1}}"
+	_, _, b := parse(src)
+	fmt.Printf("\n Input: %s\n\n%s", src, genAmd64(b))
+	fmt.Printf("\n Test 31.2 - x86-64 Backend - temporaries are spilled when registers run out \n")
+	src = "{print 1+(2+(3+(4+(5+(6+(7+(8+(9+(1+2)))))))))}"
+	_, _, b = parse(src)
+	fmt.Printf("\n Input: %s\n Pushes: %d ", src, strings.Count(genAmd64(b), "pushq %r")-1)
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" || !haveTools("cc") {
+		fmt.Printf("\n\n Test 31.3 - x86-64 Backend - skipped, needs cc on linux/amd64 \n")
+		return
+	}
+	if got, err := runAmd64(b); err != nil {
+		fmt.Printf("\n ERROR ON AMD64 BACKEND \n %s \n", err)
+	} else {
+		want, _ := evalOutput(b)
+		fmt.Printf("\n Same Output as Interpreter: %t \n", reflect.DeepEqual(printedValues(got), printedValues(want)))
+	}
+	fmt.Printf("\n Test 31.3 - x86-64 Backend - assembled with cc against the interpreter \n")
+	testBackend("AMD64 BACKEND", runAmd64)
+}
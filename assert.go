@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Runtime assertions
+//
+// A failing assert stops eval by panicking with an AssertionError, which
+// evalChecked turns back into an error. Output printed before the failure
+// stays.
+
+type AssertionError struct {
+	e    Exp
+	span Span
+	vals []string // values of the variables of e, x = v
+}
+
+func (err AssertionError) Error() string {
+	msg := "assertion failed: " + err.e.pretty()
+	if sp := err.span.pretty(); sp != "" {
+		msg = sp + ": " + msg
+	}
+	if len(err.vals) > 0 {
+		msg += " with " + strings.Join(err.vals, ", ")
+	}
+	return msg
+}
+
+// Runs b, returns the AssertionError of a failing assert
+func evalChecked(b Block, s ValState) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			a, ok := r.(AssertionError)
+			if !ok {
+				panic(r)
+			}
+			err = a
+		}
+	}()
+	b.eval(s)
+	return nil
+}
+
+func testRuntimeAssert() {
+	fmt.Printf("\n Test 38.1 - Runtime Assertions - assertion holds \n")
+	test("{varX:=3; assert varX == 3; varX = varX*2; assert varX > 5 && varX != 7; print varX}")
+	fmt.Printf("\n Test 38.2 - Runtime Assertions - failing assertion in a loop \n")
+	test("{varX:=0; while varX < 5 {print varX; assert varX < 3; varX = varX+1}}")
+	fmt.Printf("\n Test 38.3 - Runtime Assertions - values of every variable of the assertion \n")
+	test("{varX:=2; varB:=varX > 1; varY:=varX*varX; assert varB == (varY < 4) || varX == varY}")
+	test("{varX:=1; assume varX > 5; assert 2 < 1}")
+	fmt.Printf("\n Test 38.4 - Runtime Assertions - lint and intervals after an assertion \n")
+	testLintProgram("{varX:=1; assert 1 > 2; print varX}")
+	_, _, b := parse("{varX:=0; varY:=varX+4; assert varY < 3; print varY}")
+	points, ds := intervals(b)
+	fmt.Printf("\n Input: {varX:=0; varY:=varX+4; assert varY < 3; print varY}")
+	for _, p := range points {
+		fmt.Printf("\n %-22s %s", p.stmt, p.state.pretty())
+	}
+	fmt.Printf("\n Warnings: %d", len(ds))
+	for _, d := range ds {
+		fmt.Printf("\n %s", d.pretty())
+	}
+	fmt.Printf("\n")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// AST export
+//
+// The tree of a checked Block is converted to astNode values, which are then
+// printed as Graphviz DOT, JSON or an s-expression. Expressions carry the
+// type inferred for them, all nodes their source span. The parser keeps the
+// spans of the expressions of each statement in expSpans, a program read
+// from JSON has none.
+
+type astNode struct {
+	Kind     string     `json:"kind"`
+	Name     string     `json:"name,omitempty"`
+	Value    string     `json:"value,omitempty"`
+	Type     string     `json:"type,omitempty"`
+	Span     string     `json:"span,omitempty"`
+	Children []*astNode `json:"children,omitempty"`
+}
+
+func astBlock(b Block, t TyState) *astNode {
+	return &astNode{Kind: "Block", Span: b.span.pretty(), Children: []*astNode{astStmt(b.s, t)}}
+}
+
+func astStmt(s Stmt, t TyState) *astNode {
+	switch s := s.(type) {
+	case ComS:
+		return &astNode{Kind: "ComS", Span: stmtSpan(s).pretty(), Children: []*astNode{astStmt(s[0], t), astStmt(s[1], t)}}
+	case Decl:
+		n := &astNode{Kind: "Decl", Name: s.lhs, Span: s.span.pretty(), Children: []*astNode{astExp(s.rhs, t, stmtExpSpan(s.span, 0))}}
+		ty := s.ty
+		if ty == TyIllTyped {
+			ty, _ = s.rhs.infer(t)
+		}
+		t[s.lhs] = ty
+		n.Type = typeKeyword(ty)
+		return n
+	case Assign:
+		return &astNode{Kind: "Assign", Name: s.name, Span: s.span.pretty(), Children: []*astNode{astExp(s.value, t, stmtExpSpan(s.span, 0))}}
+	case Print:
+		return &astNode{Kind: "Print", Span: s.span.pretty(), Children: []*astNode{astExp(s.e, t, stmtExpSpan(s.span, 0))}}
+	case Assert:
+		return &astNode{Kind: "Assert", Span: s.span.pretty(), Children: []*astNode{astExp(s.e, t, stmtExpSpan(s.span, 0))}}
+	case Assume:
+		return &astNode{Kind: "Assume", Span: s.span.pretty(), Children: []*astNode{astExp(s.e, t, stmtExpSpan(s.span, 0))}}
+	case IfEl:
+		t1 := copyTyState(t)
+		t2 := copyTyState(t)
+		n := &astNode{Kind: "IfEl", Span: s.span.pretty(), Children: []*astNode{astExp(s.e, t, stmtExpSpan(s.span, 0)), astBlock(s.b1, t1), astBlock(s.b2, t2)}}
+		joinTyStates(t, t1, t2, "", "")
+		return n
+	case While:
+		t1 := copyTyState(t)
+		n := &astNode{Kind: "While", Span: s.span.pretty(), Children: []*astNode{astExp(s.e, t, stmtExpSpan(s.span, 0)), astBlock(s.b, t1)}}
+		if s.inv != nil {
+			// The invariant follows the body so the condition stays first
+			inv := &astNode{Kind: "Invariant"}
+			var kid *spanTree
+			if sp := stmtExpSpan(s.span, 1); sp != nil {
+				inv.Span = sp.span.pretty()
+				if len(sp.kids) == 1 {
+					kid = sp.kids[0]
+				}
+			}
+			inv.Children = []*astNode{astExp(s.inv, t, kid)}
+			n.Children = append(n.Children, inv)
+		}
+		return n
+	case Skip:
+		return &astNode{Kind: "Skip", Span: s.span.pretty()}
+	}
+	return &astNode{Kind: kindOf(s)}
+}
+
+// Name of the Go type of a node
+func kindOf(x any) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", x), "main.")
+}
+
+// Span tree of the i-th expression of the statement at sp, nil if unknown
+func stmtExpSpan(sp Span, i int) *spanTree {
+	if ts := expSpans[sp]; i < len(ts) {
+		return ts[i]
+	}
+	return nil
+}
+
+func astExp(e Exp, t TyState, sp *spanTree) *astNode {
+	ty, _ := e.infer(t)
+	n := &astNode{Kind: kindOf(e), Type: typeKeyword(ty)}
+	fs := expChildren(e)
+	var kids []*spanTree
+	if sp != nil {
+		n.Span = sp.span.pretty()
+		if len(sp.kids) == len(fs) {
+			kids = sp.kids
+		}
+	}
+	switch e := e.(type) {
+	case Num, Bool:
+		n.Value = e.pretty()
+	case Var:
+		n.Name = string(e)
+	}
+	for i, f := range fs {
+		var kid *spanTree
+		if kids != nil {
+			kid = kids[i]
+		}
+		n.Children = append(n.Children, astExp(f, t, kid))
+	}
+	return n
+}
+
+func astTree(b Block) *astNode {
+	return astBlock(b, make(TyState))
+}
+
+// S-expression, (Kind name value :type @span children...)
+
+func (n *astNode) sexpr() string {
+	x := "(" + n.Kind
+	for _, a := range []string{n.Name, n.Value} {
+		if a != "" {
+			x += " " + a
+		}
+	}
+	if n.Type != "" {
+		x += " :" + n.Type
+	}
+	if n.Span != "" {
+		x += " @" + n.Span
+	}
+	for _, c := range n.Children {
+		x += " " + c.sexpr()
+	}
+	return x + ")"
+}
+
+func (n *astNode) json() string {
+	b, _ := json.MarshalIndent(n, "", "  ")
+	return string(b) + "\n"
+}
+
+func (n *astNode) dot() string {
+	var x string
+	x = "digraph ast {\n"
+	x += "\tnode [shape=box, fontname=\"monospace\"];\n"
+	id := 0
+	var walk func(n *astNode) int
+	walk = func(n *astNode) int {
+		me := id
+		id++
+		label := n.Kind
+		for _, a := range []string{n.Name, n.Value} {
+			if a != "" {
+				label += " " + a
+			}
+		}
+		if n.Type != "" {
+			label += "\n: " + n.Type
+		}
+		if n.Span != "" {
+			label += "\n@ " + n.Span
+		}
+		x += fmt.Sprintf("\tn%d [label=%s];\n", me, dotString(label))
+		for _, c := range n.Children {
+			x += fmt.Sprintf("\tn%d -> n%d;\n", me, walk(c))
+		}
+		return me
+	}
+	walk(n)
+	x += "}\n"
+	return x
+}
+
+func testASTProgram(s string) {
+	stmt, errorAt, e := parse(s)
+	fmt.Printf("\n Input: %s", s)
+	if !stmt {
+		fmt.Printf("\n ERROR ON PARSE \n AT CHARACTER %d \n", errorAt)
+		return
+	}
+	fmt.Printf("\n Output Parse: %s", e.pretty())
+	fmt.Printf("\n AST: %s\n", astTree(e).sexpr())
+}
+
+func testAST() {
+	fmt.Printf("\n Test 26.1 - AST - s-expression with types and spans \n")
+	testASTProgram("{varX:=1; if varX<2 {print true} else {print varX+1}}")
+	fmt.Printf("\n Test 26.2 - AST - spans over several lines \n")
+	testASTProgram("{\n  varB : bool := false;\n  while varB {\n    varB = !varB\n  }\n}")
+	fmt.Printf("\n Test 26.3 - AST - spans of parentheses and invariants \n")
+	testASTProgram("{varX:=(1+2)*3; while varX > 0 invariant varX >= 0 {varX = varX-1}}")
+	fmt.Printf("\n Test 26.4 - AST - spans of skip and sequences \n")
+	testASTProgram("{skip; varX:=1;\n skip}")
+}
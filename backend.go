@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Helpers shared by the code generators
+//
+// A variable may be declared with different types in disjoint blocks, so the
+// generators name a variable after its name and type (x_int, x_bool). Names
+// of IMP variables are letters only and cannot clash with these.
+
+type typedVar struct {
+	name string
+	ty   Type
+}
+
+func typedName(x string, ty Type) string {
+	return x + "_" + typeKeyword(ty)
+}
+
+// Type of the variable declared by d, t is updated like check does
+func declare(d Decl, t TyState) Type {
+	ty := d.ty
+	if ty == TyIllTyped {
+		ty, _ = d.rhs.infer(t)
+	}
+	t[d.lhs] = ty
+	return ty
+}
+
+// Updates t for a checked statement like check does
+func typeStmt(s Stmt, t TyState) {
+	switch s := s.(type) {
+	case ComS:
+		typeStmt(s[0], t)
+		typeStmt(s[1], t)
+	case Decl:
+		declare(s, t)
+	case IfEl:
+		t1, t2 := copyTyState(t), copyTyState(t)
+		typeStmt(s.b1.s, t1)
+		typeStmt(s.b2.s, t2)
+		joinTyStates(t, t1, t2, "", "")
+	}
+}
+
+// Every variable of a checked block with the types it is declared with, in
+// order of first declaration
+func declaredVars(b Block) []typedVar {
+	var vars []typedVar
+	seen := map[typedVar]bool{}
+	var walk func(s Stmt, t TyState)
+	walk = func(s Stmt, t TyState) {
+		switch s := s.(type) {
+		case ComS:
+			walk(s[0], t)
+			walk(s[1], t)
+		case Decl:
+			v := typedVar{s.lhs, declare(s, t)}
+			if !seen[v] {
+				seen[v] = true
+				vars = append(vars, v)
+			}
+		case IfEl:
+			t1, t2 := copyTyState(t), copyTyState(t)
+			walk(s.b1.s, t1)
+			walk(s.b2.s, t2)
+			joinTyStates(t, t1, t2, "", "")
+		case While:
+			walk(s.b.s, copyTyState(t))
+		}
+	}
+	walk(b.s, make(TyState))
+	return vars
+}
+
+// Output of the interpreter and the AssertionError of a failing assert
+func evalOutput(b Block) (string, error) {
+	var buf bytes.Buffer
+	old := output
+	output = &buf
+	err := evalChecked(b, make(ValState))
+	output = old
+	return buf.String(), err
+}
+
+// Message a compiled program writes to standard error before it exits with
+// status 1 when s fails, the values of the variables are not known there
+func assertMessage(s Assert) string {
+	return AssertionError{s.e, s.span, nil}.Error()
+}
+
+// True if a backend failed with an assertion exactly when eval did
+func failsAlike(err, want error) bool {
+	if err == nil || want == nil {
+		return err == nil && want == nil
+	}
+	return strings.Contains(err.Error(), "assertion failed")
+}
+
+// Printed values, independent of the line format of a backend
+func printedValues(s string) []string {
+	return strings.Fields(s)
+}
+
+// Writes files to a temporary directory and runs the commands there one after
+// another, returns the standard output of the last command, also if it fails
+func runInTempDir(files map[string]string, cmds ...[]string) (string, error) {
+	dir, err := os.MkdirTemp("", "imp")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return "", err
+		}
+	}
+	var out []byte
+	for _, c := range cmds {
+		cmd := exec.Command(c[0], c[1:]...)
+		cmd.Dir = dir
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err = cmd.Output()
+		if err != nil {
+			return string(out), fmt.Errorf("%s: %v\n%s", strings.Join(c, " "), err, stderr.String())
+		}
+	}
+	return string(out), nil
+}
+
+// True if all tools are installed
+func haveTools(tools ...string) bool {
+	for _, t := range tools {
+		if _, err := exec.LookPath(t); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Programs every backend is tested against
+var backendCorpus = []string{
+	"{varX:=3;varY:=4;print varX+varY;print varX-varY;print varX*varY}",
+	"{varX:=1;while varX<4 {print varX; varX = varX+1}}",
+	"{varX:=true; if varX {varY:=1} else {varY:=2}; print varY; print !varX; print varX && false || true}",
+	"{varX:=5; print varX<=5; print varX>5; print varX>=6; print varX!=4; print (varX==5)==true}",
+	"{var varN int; varN = 9; varA := 0; varB := 1; while 0 < varN {varT := varA+varB; varA = varB; varB = varT; varN = varN-1}; print varA}",
+	"{varX:=1; while varX<4 {varY : bool := varX == 2; if varY {print varX} else {print varY}; varX = varX+1}; varY := 3; print varY}",
+	"{varX:=3; varI:=0; while varI < 8*5 {varX = varX*7+3; varI = varI+1}; print varX; print varX < 0}",
+	"{varX:=1; print varX; assert varX == 1; varX = varX+1; print varX; assert varX == 1; print 5}",
+}
+
+// Runs every program of the corpus through the interpreter and run, which
+// returns the output of the compiled program and an error if it failed
+func testBackend(name string, run func(b Block) (string, error)) {
+	for i, src := range backendCorpus {
+		ok, errorAt, b := parse(src)
+		fmt.Printf("\n Program %d: %s", i+1, src)
+		if !ok {
+			fmt.Printf("\n ERROR ON PARSE \n AT CHARACTER %d \n", errorAt)
+			continue
+		}
+		got, err := run(b)
+		want, wantErr := evalOutput(b)
+		if err != nil && wantErr == nil {
+			fmt.Printf("\n ERROR ON %s \n %s \n", name, err)
+			continue
+		}
+		same := failsAlike(err, wantErr) && reflect.DeepEqual(printedValues(got), printedValues(want))
+		fmt.Printf("\n Same Output as Interpreter: %t ", same)
+	}
+	fmt.Printf("\n")
+}
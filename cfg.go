@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Control-flow graph
+//
+// Basic blocks hold the simple statements (Decl, Assign, Print, Assert,
+// Assume) in order. A block ending in a condition (of IfEl or While) has two
+// successors, the first is taken if the condition is true. Otherwise a block
+// has at most one successor, the exit block has none.
+
+type BasicBlock struct {
+	id    int
+	stmts []Stmt
+	cond  Exp
+	succ  []int
+}
+
+type CFG struct {
+	blocks []*BasicBlock
+	entry  int
+	exit   int
+}
+
+func (g *CFG) newBlock() *BasicBlock {
+	bb := &BasicBlock{id: len(g.blocks)}
+	g.blocks = append(g.blocks, bb)
+	return bb
+}
+
+func (g *CFG) jump(from, to *BasicBlock) {
+	from.succ = append(from.succ, to.id)
+}
+
+// Predecessors of every block, indexed by block id
+func (g *CFG) preds() [][]int {
+	p := make([][]int, len(g.blocks))
+	for _, bb := range g.blocks {
+		for _, s := range bb.succ {
+			p[s] = append(p[s], bb.id)
+		}
+	}
+	return p
+}
+
+func buildCFG(b Block) *CFG {
+	g := &CFG{}
+	entry := g.newBlock()
+	end := g.addStmt(b.s, entry)
+	exit := g.newBlock()
+	g.jump(end, exit)
+	g.entry = entry.id
+	g.exit = exit.id
+	return g
+}
+
+// Adds s starting in block cur, returns the block control reaches after s
+func (g *CFG) addStmt(s Stmt, cur *BasicBlock) *BasicBlock {
+	switch s := s.(type) {
+	case ComS:
+		return g.addStmt(s[1], g.addStmt(s[0], cur))
+	case IfEl:
+		cur.cond = s.e
+		then := g.newBlock()
+		els := g.newBlock()
+		g.jump(cur, then)
+		g.jump(cur, els)
+		end1 := g.addStmt(s.b1.s, then)
+		end2 := g.addStmt(s.b2.s, els)
+		join := g.newBlock()
+		g.jump(end1, join)
+		g.jump(end2, join)
+		return join
+	case While:
+		head := cur
+		if len(cur.stmts) > 0 {
+			head = g.newBlock()
+			g.jump(cur, head)
+		}
+		head.cond = s.e
+		body := g.newBlock()
+		after := g.newBlock()
+		g.jump(head, body)
+		g.jump(head, after)
+		g.jump(g.addStmt(s.b.s, body), head)
+		return after
+	case Skip:
+		return cur
+	}
+	cur.stmts = append(cur.stmts, s)
+	return cur
+}
+
+func (g *CFG) label(bb *BasicBlock) string {
+	var lines []string
+	switch bb.id {
+	case g.entry:
+		lines = append(lines, "B"+strconv.Itoa(bb.id)+" (entry)")
+	case g.exit:
+		lines = append(lines, "B"+strconv.Itoa(bb.id)+" (exit)")
+	default:
+		lines = append(lines, "B"+strconv.Itoa(bb.id))
+	}
+	for _, s := range bb.stmts {
+		lines = append(lines, s.pretty())
+	}
+	if bb.cond != nil {
+		lines = append(lines, "if "+bb.cond.pretty())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Textual listing of the blocks
+func (g *CFG) pretty() string {
+	var x string
+	for _, bb := range g.blocks {
+		x += g.label(bb)
+		switch {
+		case bb.cond != nil:
+			x += fmt.Sprintf(" goto B%d else B%d", bb.succ[0], bb.succ[1])
+		case len(bb.succ) == 1:
+			x += fmt.Sprintf("\ngoto B%d", bb.succ[0])
+		}
+		x += "\n\n"
+	}
+	return x
+}
+
+func dotString(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "\"", "\\\"")
+	return "\"" + strings.ReplaceAll(s, "\n", "\\l") + "\\l\""
+}
+
+// Graphviz DOT
+func (g *CFG) dot() string {
+	var x string
+	x = "digraph cfg {\n"
+	x += "\tnode [shape=box, fontname=\"monospace\"];\n"
+	for _, bb := range g.blocks {
+		x += fmt.Sprintf("\tB%d [label=%s];\n", bb.id, dotString(g.label(bb)))
+	}
+	for _, bb := range g.blocks {
+		for i, s := range bb.succ {
+			switch {
+			case bb.cond != nil && i == 0:
+				x += fmt.Sprintf("\tB%d -> B%d [label=\"true\"];\n", bb.id, s)
+			case bb.cond != nil:
+				x += fmt.Sprintf("\tB%d -> B%d [label=\"false\"];\n", bb.id, s)
+			default:
+				x += fmt.Sprintf("\tB%d -> B%d;\n", bb.id, s)
+			}
+		}
+	}
+	x += "}\n"
+	return x
+}
+
+func testCFGProgram(s string) {
+	stmt, errorAt, e := parse(s)
+	fmt.Printf("\n Input: %s", s)
+	if !stmt {
+		fmt.Printf("\n ERROR ON PARSE \n AT CHARACTER %d \n", errorAt)
+		return
+	}
+	fmt.Printf("\n Output Parse: %s", e.pretty())
+	fmt.Printf("\n CFG: \n%s", buildCFG(e).pretty())
+}
+
+func testCFG() {
+	fmt.Printf("\n Test 25.1 - CFG - if else \n")
+	testCFGProgram("{varX:=1; if varX<2 {print true} else {print false}; print varX}")
+	fmt.Printf("\n Test 25.2 - CFG - while \n")
+	testCFGProgram("{varX:=1; while varX<4 {print varX; varX = varX+1}; print varX}")
+}
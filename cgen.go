@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// C backend
+//
+// Translates a checked Block into a C99 translation unit. Ints are int64_t,
+// bools _Bool, all variables are declared at the start of main. Arithmetic is
+// done on uint64_t so that overflow wraps around like Go int does. A failing
+// assert writes its message to stderr and returns 1 from main.
+
+type cGen struct {
+	buf    strings.Builder
+	indent int
+}
+
+func genC(b Block) string {
+	g := &cGen{indent: 1}
+	g.buf.WriteString("#include <inttypes.h>\n#include <stdint.h>\n#include <stdio.h>\n\n")
+	g.buf.WriteString("int main(void) {\n")
+	for _, v := range declaredVars(b) {
+		if v.ty == TyBool {
+			g.line("_Bool %s = 0;", typedName(v.name, v.ty))
+		} else {
+			g.line("int64_t %s = 0;", typedName(v.name, v.ty))
+		}
+	}
+	g.stmt(b.s, make(TyState))
+	g.line("return 0;")
+	g.buf.WriteString("}\n")
+	return g.buf.String()
+}
+
+func (g *cGen) line(format string, args ...any) {
+	g.buf.WriteString(strings.Repeat("\t", g.indent))
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteString("\n")
+}
+
+func (g *cGen) block(b Block, t TyState) {
+	g.indent++
+	g.stmt(b.s, t)
+	g.indent--
+}
+
+func (g *cGen) stmt(s Stmt, t TyState) {
+	switch s := s.(type) {
+	case ComS:
+		g.stmt(s[0], t)
+		g.stmt(s[1], t)
+	case Decl:
+		e := cExp(s.rhs, t)
+		g.line("%s = %s;", typedName(s.lhs, declare(s, t)), e)
+	case Assign:
+		g.line("%s = %s;", typedName(s.name, t[s.name]), cExp(s.value, t))
+	case Print:
+		if ty, _ := s.e.infer(t); ty == TyBool {
+			g.line("puts(%s ? \"true\" : \"false\");", cExp(s.e, t))
+		} else {
+			g.line("printf(\"%%\" PRId64 \"\\n\", %s);", cExp(s.e, t))
+		}
+	case Assert:
+		g.line("if (!%s) {", cCond(s.e, t))
+		g.indent++
+		g.line("fputs(%s, stderr);", strconv.Quote(assertMessage(s)+"\n"))
+		g.line("return 1;")
+		g.indent--
+		g.line("}")
+	case IfEl:
+		t1, t2 := copyTyState(t), copyTyState(t)
+		g.line("if %s {", cCond(s.e, t))
+		g.block(s.b1, t1)
+		g.line("} else {")
+		g.block(s.b2, t2)
+		g.line("}")
+		joinTyStates(t, t1, t2, "", "")
+	case While:
+		g.line("while %s {", cCond(s.e, t))
+		g.block(s.b, copyTyState(t))
+		g.line("}")
+	}
+}
+
+// Condition in parentheses, as if and while need them
+func cCond(e Exp, t TyState) string {
+	c := cExp(e, t)
+	if strings.HasPrefix(c, "(") && strings.HasSuffix(c, ")") && isBinary(e) {
+		return c
+	}
+	return "(" + c + ")"
+}
+
+func isBinary(e Exp) bool {
+	return len(expChildren(e)) == 2
+}
+
+func cExp(e Exp, t TyState) string {
+	switch e := e.(type) {
+	case Num:
+		// -9223372036854775808 is the negation of a constant out of range
+		if int64(e) == math.MinInt64 {
+			return "INT64_MIN"
+		}
+		return fmt.Sprintf("INT64_C(%d)", int(e))
+	case Bool:
+		if e {
+			return "1"
+		}
+		return "0"
+	case Var:
+		return typedName(string(e), t[string(e)])
+	case Plus:
+		return cWrap(e[0], "+", e[1], t)
+	case Minus:
+		return cWrap(e[0], "-", e[1], t)
+	case Mult:
+		return cWrap(e[0], "*", e[1], t)
+	case Neg:
+		return "!" + cExp(e[0], t)
+	case And:
+		return "(" + cExp(e[0], t) + " && " + cExp(e[1], t) + ")"
+	case Or:
+		return "(" + cExp(e[0], t) + " || " + cExp(e[1], t) + ")"
+	case Equ:
+		return "(" + cExp(e[0], t) + " == " + cExp(e[1], t) + ")"
+	case Neq:
+		return "(" + cExp(e[0], t) + " != " + cExp(e[1], t) + ")"
+	case Les:
+		return "(" + cExp(e[0], t) + " < " + cExp(e[1], t) + ")"
+	case Leq:
+		return "(" + cExp(e[0], t) + " <= " + cExp(e[1], t) + ")"
+	case Gre:
+		return "(" + cExp(e[0], t) + " > " + cExp(e[1], t) + ")"
+	case Geq:
+		return "(" + cExp(e[0], t) + " >= " + cExp(e[1], t) + ")"
+	}
+	return "0"
+}
+
+// Wrapping integer arithmetic
+func cWrap(x Exp, op string, y Exp, t TyState) string {
+	return "(int64_t)((uint64_t)" + cExp(x, t) + " " + op + " (uint64_t)" + cExp(y, t) + ")"
+}
+
+func runC(b Block) (string, error) {
+	return runInTempDir(map[string]string{"prog.c": genC(b)},
+		[]string{"cc", "-std=c99", "-O1", "-o", "prog", "prog.c"},
+		[]string{"./prog"})
+}
+
+func testCBackend() {
+	fmt.Printf("\n Test 28.1 - C Backend - generated code \n")
+	src := "{varX:=1;while varX<4 {print varX<3; varX = varX+1}}"
+	_, _, b := parse(src)
+	fmt.Printf("\n Input: %s\n\n%s", src, genC(b))
+	fmt.Printf("\n Test 28.2 - C Backend - compiled with cc against the interpreter \n")
+	if !haveTools("cc") {
+		fmt.Printf("\n cc not found, skipped \n")
+		return
+	}
+	testBackend("C BACKEND", runC)
+	fmt.Printf("\n Test 28.3 - C Backend - the smallest int, only from JSON or folding \n")
+	b = Block{s: Print{e: Minus{Num(math.MinInt64), Num(1)}}}
+	fmt.Printf("\n Expression: %s", cExp(Minus{Num(math.MinInt64), Num(1)}, TyState{}))
+	if got, err := runC(b); err != nil {
+		fmt.Printf("\n ERROR ON C BACKEND \n %s \n", err)
+	} else {
+		want, _ := evalOutput(b)
+		fmt.Printf("\n Same Output as Interpreter: %t \n", reflect.DeepEqual(printedValues(got), printedValues(want)))
+	}
+}
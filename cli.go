@@ -0,0 +1,469 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Command line interface
+//
+//	imp run [prog.imp]
+//	imp build -target=c|go|wat|wasm|amd64|llvm|js [-o file] [prog.imp]
+//	imp ast [--format=sexpr|json|dot] [prog.imp]
+//	imp cfg [--dot] [prog.imp]
+//	imp check [--Werror] [prog.imp]
+//	imp json [prog.imp]
+//	imp opt [--print] [prog.imp]
+//	imp ir [-O=passes] [--run] [prog.imp]
+//	imp ranges [prog.imp]
+//	imp verify [--emit-smt] [--solver=auto|z3|cvc5|none] [prog.imp]
+//	imp symex -inputs=x,y [-bound=n] [prog.imp]
+//	imp debug prog.imp
+//	imp dap
+//
+// Without a file name the program is read from standard input, imp debug
+// reads its commands from there instead. Instead of source code every
+// command also accepts an AST serialized by imp json.
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: imp <command> [flags] [prog.imp]\n\n")
+	fmt.Fprintf(os.Stderr, "commands:\n")
+	fmt.Fprintf(os.Stderr, "  run     check and run a program\n")
+	fmt.Fprintf(os.Stderr, "  build   compile a program for a target\n")
+	fmt.Fprintf(os.Stderr, "  ast     print the syntax tree of a program\n")
+	fmt.Fprintf(os.Stderr, "  cfg     print the control-flow graph of a program\n")
+	fmt.Fprintf(os.Stderr, "  check   type check a program and report warnings\n")
+	fmt.Fprintf(os.Stderr, "  json    serialize the syntax tree of a program\n")
+	fmt.Fprintf(os.Stderr, "  opt     optimize a program and run it\n")
+	fmt.Fprintf(os.Stderr, "  ir      print the SSA intermediate representation\n")
+	fmt.Fprintf(os.Stderr, "  ranges  print the intervals of the variables at every statement\n")
+	fmt.Fprintf(os.Stderr, "  verify  prove the assertions and loop invariants of a program\n")
+	fmt.Fprintf(os.Stderr, "  symex   list the paths of a program with inputs that take them\n")
+	fmt.Fprintf(os.Stderr, "  debug   run a program step by step\n")
+	fmt.Fprintf(os.Stderr, "  dap     serve the Debug Adapter Protocol on standard input and output\n")
+}
+
+func runCommand(args []string) int {
+	switch args[0] {
+	case "run":
+		return cmdRun(args[1:])
+	case "build":
+		return cmdBuild(args[1:])
+	case "json":
+		return cmdJSON(args[1:])
+	case "ast":
+		return cmdAST(args[1:])
+	case "cfg":
+		return cmdCFG(args[1:])
+	case "check":
+		return cmdCheck(args[1:])
+	case "opt":
+		return cmdOpt(args[1:])
+	case "ir":
+		return cmdIR(args[1:])
+	case "ranges":
+		return cmdRanges(args[1:])
+	case "verify":
+		return cmdVerify(args[1:])
+	case "symex":
+		return cmdSymex(args[1:])
+	case "debug":
+		return cmdDebug(args[1:])
+	case "dap":
+		serveDAP(os.Stdin, os.Stdout)
+		return 0
+	}
+	usage()
+	return 2
+}
+
+func readProgram(path string) (string, error) {
+	var b []byte
+	var err error
+	if path == "" || path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	return string(b), err
+}
+
+// Parses and checks a program, errors read like the output of test()
+func loadProgram(src string) (Block, error) {
+	e, ds := checkProgram(src)
+	if failed(ds, false) {
+		var msgs []string
+		for _, d := range ds {
+			msgs = append(msgs, d.msg)
+		}
+		return Block{}, errors.New(strings.Join(msgs, "\n"))
+	}
+	return e, nil
+}
+
+// Parse, definite assignment and type check errors of a program
+func checkProgram(src string) (Block, []Diagnostic) {
+	var e Block
+	if isJSONProgram(src) {
+		var err error
+		e, err = Unmarshal([]byte(src))
+		if err != nil {
+			return Block{}, []Diagnostic{errorDiag("ERROR ON UNMARSHAL " + err.Error())}
+		}
+	} else {
+		var ok bool
+		var errorAt int
+		ok, errorAt, e = parse(src)
+		if !ok {
+			return Block{}, []Diagnostic{errorDiag(fmt.Sprintf("ERROR ON PARSE AT CHARACTER %d", errorAt))}
+		}
+	}
+	var ds []Diagnostic
+	for _, err := range definiteAssignment(e) {
+		ds = append(ds, errorDiag(err))
+	}
+	if len(ds) > 0 {
+		return e, ds
+	}
+	errorDetail = ""
+	declScopes = nil
+	checked, errorIn, errorAtExp := e.check(make(TyState))
+	if !checked {
+		return e, []Diagnostic{errorDiag(illTypedMessage(errorIn, errorAtExp))}
+	}
+	return e, nil
+}
+
+// Reads, parses and checks the program named by the first argument of fs
+func loadFlagProgram(fs *flag.FlagSet) (Block, bool) {
+	src, err := readProgram(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return Block{}, false
+	}
+	b, err := loadProgram(src)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return Block{}, false
+	}
+	return b, true
+}
+
+func cmdRun(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	b, ok := loadFlagProgram(fs)
+	if !ok {
+		return 1
+	}
+	err := evalChecked(b, make(ValState))
+	fmt.Println()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func cmdBuild(args []string) int {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	target := fs.String("target", "c", "target: c, go, wat, wasm, amd64, llvm, js")
+	out := fs.String("o", "", "output file, standard output if empty")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	b, ok := loadFlagProgram(fs)
+	if !ok {
+		return 1
+	}
+	var code string
+	switch *target {
+	case "c":
+		code = genC(b)
+	case "go":
+		code = genGo(b)
+	case "wat":
+		code = genWasm(b).wat()
+	case "wasm":
+		code = string(genWasm(b).binary())
+	case "amd64":
+		code = genAmd64(b)
+	case "llvm":
+		code = genLLVM(b)
+	case "js":
+		code = genJS(b)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown target %s\n", *target)
+		return 2
+	}
+	if *out == "" {
+		fmt.Print(code)
+		return 0
+	}
+	if err := os.WriteFile(*out, []byte(code), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func cmdJSON(args []string) int {
+	fs := flag.NewFlagSet("json", flag.ContinueOnError)
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	b, ok := loadFlagProgram(fs)
+	if !ok {
+		return 1
+	}
+	data, err := Marshal(b)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
+
+func cmdAST(args []string) int {
+	fs := flag.NewFlagSet("ast", flag.ContinueOnError)
+	format := fs.String("format", "sexpr", "output format: sexpr, json or dot")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	b, ok := loadFlagProgram(fs)
+	if !ok {
+		return 1
+	}
+	n := astTree(b)
+	switch *format {
+	case "sexpr":
+		fmt.Println(n.sexpr())
+	case "json":
+		fmt.Print(n.json())
+	case "dot":
+		fmt.Print(n.dot())
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %s\n", *format)
+		return 2
+	}
+	return 0
+}
+
+func cmdCFG(args []string) int {
+	fs := flag.NewFlagSet("cfg", flag.ContinueOnError)
+	dot := fs.Bool("dot", false, "emit Graphviz DOT")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	b, ok := loadFlagProgram(fs)
+	if !ok {
+		return 1
+	}
+	g := buildCFG(b)
+	if *dot {
+		fmt.Print(g.dot())
+	} else {
+		fmt.Print(g.pretty())
+	}
+	return 0
+}
+
+func cmdCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	werror := fs.Bool("Werror", false, "treat warnings as errors")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	src, err := readProgram(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	b, ds := checkProgram(src)
+	if !failed(ds, false) {
+		ds = append(ds, lint(b)...)
+		_, rs := intervals(b)
+		ds = append(ds, rs...)
+		ds = append(ds, termination(b)...)
+	}
+	for _, d := range ds {
+		fmt.Println(d.pretty())
+	}
+	if failed(ds, *werror) {
+		return 1
+	}
+	return 0
+}
+
+func cmdOpt(args []string) int {
+	fs := flag.NewFlagSet("opt", flag.ContinueOnError)
+	printOnly := fs.Bool("print", false, "print the optimized program instead of running it")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	b, ok := loadFlagProgram(fs)
+	if !ok {
+		return 1
+	}
+	b = optimize(b)
+	if *printOnly {
+		fmt.Println(b.pretty())
+		return 0
+	}
+	err := evalChecked(b, make(ValState))
+	fmt.Println()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func cmdIR(args []string) int {
+	fs := flag.NewFlagSet("ir", flag.ContinueOnError)
+	run := fs.Bool("run", false, "run the IR instead of printing it")
+	passes := fs.String("O", "none", "optimization passes: all, none or a list of copyprop,cse,licm,dce")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	on, err := parsePasses(*passes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	b, ok := loadFlagProgram(fs)
+	if !ok {
+		return 1
+	}
+	f := buildIR(b)
+	optimizeIR(f, on)
+	if !*run {
+		fmt.Print(f.pretty())
+		return 0
+	}
+	err = f.run(os.Stdout)
+	fmt.Println()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func cmdRanges(args []string) int {
+	fs := flag.NewFlagSet("ranges", flag.ContinueOnError)
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	b, ok := loadFlagProgram(fs)
+	if !ok {
+		return 1
+	}
+	points, ds := intervals(b)
+	for _, p := range points {
+		fmt.Printf("%-7s %-24s %s\n", fmt.Sprintf("%d:%d", p.span.line, p.span.col), p.stmt, p.state.pretty())
+	}
+	for _, d := range ds {
+		fmt.Println(d.pretty())
+	}
+	return 0
+}
+
+func cmdVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	emit := fs.Bool("emit-smt", false, "print the verification conditions in SMT-LIB 2 instead of checking them")
+	solver := fs.String("solver", "auto", "SMT solver: auto, z3, cvc5 or none")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	b, ok := loadFlagProgram(fs)
+	if !ok {
+		return 1
+	}
+	vcs := verificationConditions(b)
+	if *emit {
+		fmt.Print(smtScript(vcs))
+		return 0
+	}
+	if *solver != "none" {
+		name, out, err := runSolver(*solver, smtScript(vcs))
+		if err == nil && name != "" {
+			err = applyAnswers(vcs, out)
+		}
+		switch {
+		case err != nil:
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		case name == "":
+			fmt.Fprintln(os.Stderr, "no SMT solver found (z3, cvc5), only conditions decided by simplification are reported")
+		}
+	}
+	for _, vc := range vcs {
+		fmt.Println(vc.pretty())
+	}
+	return verifyExitCode(vcs)
+}
+
+func cmdSymex(args []string) int {
+	fs := flag.NewFlagSet("symex", flag.ContinueOnError)
+	inputs := fs.String("inputs", "", "comma separated variables whose declarations read a symbolic input")
+	bound := fs.Int("bound", 4, "iterations of a while loop per path")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	b, ok := loadFlagProgram(fs)
+	if !ok {
+		return 1
+	}
+	var names []string
+	for _, x := range strings.Split(*inputs, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			names = append(names, x)
+		}
+	}
+	paths, truncated := symbolicPaths(b, names, *bound)
+	code := 0
+	for i, p := range paths {
+		fmt.Printf("path %d: %s\n", i+1, strings.ReplaceAll(p.pretty(), "\n", "\n\t"))
+		if strings.HasPrefix(p.end, "assertion failed") && p.status != satNo {
+			code = 1
+		}
+	}
+	if truncated {
+		fmt.Printf("more than %d paths, the rest was dropped\n", maxSymPaths)
+	}
+	return code
+}
+
+func cmdDebug(args []string) int {
+	fs := flag.NewFlagSet("debug", flag.ContinueOnError)
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	if fs.Arg(0) == "" || fs.Arg(0) == "-" {
+		fmt.Fprintln(os.Stderr, "imp debug needs a file name, the commands are read from standard input")
+		return 2
+	}
+	src, err := readProgram(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	b, err := loadProgram(src)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := newDebugger(src, b, os.Stdin, os.Stdout).run(b); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
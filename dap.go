@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Debug Adapter Protocol
+//
+// imp dap serves one debug session over standard input and output. Messages
+// are JSON with a Content-Length header. The requests initialize, launch,
+// setBreakpoints, configurationDone, threads, stackTrace, scopes, variables,
+// evaluate, continue, next, stepIn, stepOut, pause and disconnect are
+// supported. The program runs in a goroutine with a hook that asks a
+// debugger (debugger.go) whether to stop and then blocks until a request
+// resumes it. IMP has no procedures, so there is one thread with one stack
+// frame whose only scope holds the variables of the ValState. print sends
+// output events.
+
+type dapMessage struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command,omitempty"`
+	Event      string          `json:"event,omitempty"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	RequestSeq int             `json:"request_seq,omitempty"`
+	Success    *bool           `json:"success,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+}
+
+type dapObject map[string]any
+
+const (
+	dapThread = 1
+	dapFrame  = 1
+	dapLocals = 1 // variablesReference of the only scope
+)
+
+func readDAP(r *bufio.Reader) (dapMessage, error) {
+	var m dapMessage
+	n := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return m, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if v, ok := strings.CutPrefix(line, "Content-Length:"); ok {
+			if n, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return m, err
+			}
+		}
+	}
+	if n < 0 {
+		return m, errors.New("dap: missing Content-Length")
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return m, err
+	}
+	err := json.Unmarshal(buf, &m)
+	return m, err
+}
+
+func writeDAP(w io.Writer, m dapMessage) error {
+	b, err := dapJSON(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	return err
+}
+
+// json.Marshal without escaping <, > and & in strings
+func dapJSON(v any) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+type dapServer struct {
+	mu  sync.Mutex // guards everything below but the channels
+	w   io.Writer
+	seq int
+	// Set by launch
+	path        string
+	prog        Block
+	d           *debugger
+	stopOnEntry bool
+	started     bool
+	// Set while the program is stopped
+	stopped bool
+	stmt    Stmt
+	at      Span
+	vals    ValState
+	// Reason of the next stop, if not the debugger's
+	reason   string
+	quitting bool
+	resume   chan bool // false ends the program
+	done     chan struct{}
+}
+
+// Serves a session until disconnect or the end of r
+func serveDAP(r io.Reader, w io.Writer) {
+	s := &dapServer{w: w, resume: make(chan bool), done: make(chan struct{})}
+	saved := output
+	output = dapOutput{s}
+	defer func() { output = saved }()
+	br := bufio.NewReader(r)
+	for {
+		m, err := readDAP(br)
+		if err != nil {
+			s.end()
+			return
+		}
+		if m.Type == "request" && s.handle(m) {
+			return
+		}
+	}
+}
+
+func (s *dapServer) send(m dapMessage, body any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	m.Seq = s.seq
+	if body != nil {
+		m.Body, _ = dapJSON(body)
+	}
+	writeDAP(s.w, m)
+}
+
+func (s *dapServer) event(name string, body any) {
+	s.send(dapMessage{Type: "event", Event: name}, body)
+}
+
+func (s *dapServer) respond(req dapMessage, body any) {
+	ok := true
+	s.send(dapMessage{Type: "response", Command: req.Command, RequestSeq: req.Seq, Success: &ok}, body)
+}
+
+func (s *dapServer) fail(req dapMessage, msg string) {
+	ok := false
+	s.send(dapMessage{Type: "response", Command: req.Command, RequestSeq: req.Seq, Success: &ok, Message: msg}, nil)
+}
+
+// Writes of print become output events
+type dapOutput struct{ s *dapServer }
+
+func (o dapOutput) Write(p []byte) (int, error) {
+	o.s.event("output", dapObject{"category": "stdout", "output": string(p)})
+	return len(p), nil
+}
+
+// Handles a request, true after disconnect
+func (s *dapServer) handle(req dapMessage) bool {
+	var args struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+		Lines       []int  `json:"lines"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+		VariablesReference int    `json:"variablesReference"`
+		Expression         string `json:"expression"`
+	}
+	if len(req.Arguments) > 0 {
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, err.Error())
+			return false
+		}
+	}
+	switch req.Command {
+	case "initialize":
+		s.respond(req, dapObject{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+		})
+	case "launch":
+		s.launch(req, args.Program, args.StopOnEntry)
+	case "setBreakpoints":
+		lines := args.Lines
+		for _, b := range args.Breakpoints {
+			lines = append(lines, b.Line)
+		}
+		s.setBreakpoints(req, lines)
+	case "configurationDone":
+		s.respond(req, nil)
+		s.start()
+	case "threads":
+		s.respond(req, dapObject{"threads": []dapObject{{"id": dapThread, "name": "main"}}})
+	case "stackTrace":
+		s.stackTrace(req)
+	case "scopes":
+		s.respond(req, dapObject{"scopes": []dapObject{
+			{"name": "Locals", "variablesReference": dapLocals, "expensive": false},
+		}})
+	case "variables":
+		s.variables(req, args.VariablesReference)
+	case "evaluate":
+		s.evaluate(req, args.Expression)
+	case "continue":
+		s.step(req, debugContinue, dapObject{"allThreadsContinued": true})
+	case "next":
+		s.step(req, debugNext, nil)
+	case "stepIn":
+		s.step(req, debugStep, nil)
+	case "stepOut":
+		s.step(req, debugOut, nil)
+	case "pause":
+		s.mu.Lock()
+		if s.d != nil && !s.stopped {
+			s.d.mode = debugStep
+			s.reason = "pause"
+		}
+		s.mu.Unlock()
+		s.respond(req, nil)
+	case "disconnect":
+		s.end()
+		s.respond(req, nil)
+		return true
+	default:
+		s.fail(req, "unsupported request "+req.Command)
+	}
+	return false
+}
+
+func (s *dapServer) launch(req dapMessage, path string, stopOnEntry bool) {
+	if s.d != nil {
+		s.fail(req, "a program is already launched")
+		return
+	}
+	src, err := readProgram(path)
+	if err == nil && path == "" {
+		err = errors.New("launch needs a program")
+	}
+	if err != nil {
+		s.fail(req, err.Error())
+		return
+	}
+	b, err := loadProgram(src)
+	if err != nil {
+		s.fail(req, err.Error())
+		return
+	}
+	s.mu.Lock()
+	s.path, s.prog, s.stopOnEntry = path, b, stopOnEntry
+	s.d = newDebugger(src, b, nil, io.Discard)
+	s.mu.Unlock()
+	s.respond(req, nil)
+	s.event("initialized", nil)
+}
+
+func (s *dapServer) setBreakpoints(req dapMessage, lines []int) {
+	s.mu.Lock()
+	if s.d == nil {
+		s.mu.Unlock()
+		s.fail(req, "no program launched")
+		return
+	}
+	s.d.breaks = map[int]bool{}
+	bs := []dapObject{}
+	for _, n := range lines {
+		b := dapObject{"line": n, "verified": s.d.setBreak(n)}
+		if !b["verified"].(bool) {
+			b["message"] = fmt.Sprintf("no statement starts on line %d", n)
+		}
+		bs = append(bs, b)
+	}
+	s.mu.Unlock()
+	s.respond(req, dapObject{"breakpoints": bs})
+}
+
+// Runs the program once configurationDone came
+func (s *dapServer) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.d == nil || s.started {
+		return
+	}
+	s.started = true
+	if s.stopOnEntry {
+		s.d.mode = debugStep
+		s.reason = "entry"
+	} else {
+		s.d.mode = debugContinue
+	}
+	go s.run()
+}
+
+func (s *dapServer) run() {
+	defer close(s.done)
+	quit, err := runHooked(s.prog, s.pause)
+	if quit {
+		s.event("terminated", nil)
+		return
+	}
+	code := 0
+	if err != nil {
+		s.event("output", dapObject{"category": "stderr", "output": err.Error() + "\n"})
+		code = 1
+	}
+	s.event("exited", dapObject{"exitCode": code})
+	s.event("terminated", nil)
+}
+
+// The statement hook of the program
+func (s *dapServer) pause(st Stmt, sp Span, vals ValState) {
+	s.mu.Lock()
+	if s.quitting {
+		s.mu.Unlock()
+		panic(debugQuit{})
+	}
+	reason := s.d.stops(sp)
+	if reason == "step" && s.reason != "" {
+		reason = s.reason
+	}
+	if reason == "" {
+		s.mu.Unlock()
+		return
+	}
+	s.reason = ""
+	s.stopped, s.stmt, s.at, s.vals = true, st, sp, vals
+	s.mu.Unlock()
+	s.event("stopped", dapObject{"reason": reason, "threadId": dapThread, "allThreadsStopped": true})
+	if !<-s.resume {
+		panic(debugQuit{})
+	}
+}
+
+// Resumes the stopped program in mode
+func (s *dapServer) step(req dapMessage, mode debugMode, body any) {
+	s.mu.Lock()
+	if !s.stopped {
+		s.mu.Unlock()
+		s.fail(req, "the program is not stopped")
+		return
+	}
+	s.d.resume(mode, s.at)
+	s.stopped = false
+	s.mu.Unlock()
+	s.respond(req, body)
+	s.resume <- true
+}
+
+// Ends the program and waits for it
+func (s *dapServer) end() {
+	s.mu.Lock()
+	started, stopped := s.started, s.stopped
+	s.quitting = true
+	s.stopped = false
+	s.mu.Unlock()
+	if !started {
+		return
+	}
+	if stopped {
+		s.resume <- false
+	}
+	<-s.done
+}
+
+// State of the stopped program, ok is false while it runs
+func (s *dapServer) state() (st Stmt, sp Span, vals ValState, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stmt, s.at, s.vals, s.stopped
+}
+
+func (s *dapServer) stackTrace(req dapMessage) {
+	st, sp, _, ok := s.state()
+	if !ok {
+		s.respond(req, dapObject{"stackFrames": []dapObject{}, "totalFrames": 0})
+		return
+	}
+	frame := dapObject{
+		"id":        dapFrame,
+		"name":      stmtHead(st),
+		"line":      sp.line,
+		"column":    sp.col,
+		"endLine":   sp.endLine,
+		"endColumn": sp.endCol,
+		"source":    dapObject{"name": filepath.Base(s.path), "path": s.path},
+	}
+	s.respond(req, dapObject{"stackFrames": []dapObject{frame}, "totalFrames": 1})
+}
+
+func (s *dapServer) variables(req dapMessage, ref int) {
+	_, _, vals, ok := s.state()
+	vars := []dapObject{}
+	if ok && ref == dapLocals {
+		var names []string
+		for x := range vals {
+			names = append(names, x)
+		}
+		sort.Strings(names)
+		for _, x := range names {
+			vars = append(vars, dapObject{
+				"name":               x,
+				"value":              showVal(vals[x]),
+				"type":               typeKeyword(valType(vals[x])),
+				"variablesReference": 0,
+			})
+		}
+	}
+	s.respond(req, dapObject{"variables": vars})
+}
+
+func (s *dapServer) evaluate(req dapMessage, src string) {
+	_, _, vals, ok := s.state()
+	if !ok {
+		s.fail(req, "the program is not stopped")
+		return
+	}
+	_, v, err := evalExpression(src, vals)
+	if err != nil {
+		s.fail(req, err.Error())
+		return
+	}
+	s.respond(req, dapObject{"result": showVal(v), "type": typeKeyword(valType(v)), "variablesReference": 0})
+}
+
+// Scripted client for the tests
+
+type dapClient struct {
+	r   *bufio.Reader
+	w   io.Writer
+	seq int
+	dir string // printed as $TMP
+}
+
+func (c *dapClient) show(m dapMessage) {
+	var x string
+	switch m.Type {
+	case "response":
+		status := "ok"
+		if m.Success == nil || !*m.Success {
+			status = "failed: " + m.Message
+		}
+		x = "<- response " + m.Command + " " + status
+	case "event":
+		x = "<- event " + m.Event
+	}
+	if len(m.Body) > 0 {
+		x += " " + string(m.Body)
+	}
+	fmt.Printf("\n %s", strings.ReplaceAll(x, c.dir, "$TMP"))
+}
+
+// Sends a request and prints the messages up to its response
+func (c *dapClient) request(command string, args any) {
+	c.seq++
+	m := dapMessage{Seq: c.seq, Type: "request", Command: command}
+	if args != nil {
+		m.Arguments, _ = dapJSON(args)
+	}
+	fmt.Printf("\n %s", strings.ReplaceAll(strings.TrimSpace("-> "+command+" "+string(m.Arguments)), c.dir, "$TMP"))
+	// The server may be writing events that are read below
+	go writeDAP(c.w, m)
+	for {
+		r, err := readDAP(c.r)
+		if err != nil {
+			fmt.Printf("\n %s", err)
+			return
+		}
+		c.show(r)
+		if r.Type == "response" && r.RequestSeq == m.Seq {
+			return
+		}
+	}
+}
+
+// Prints the messages up to the event
+func (c *dapClient) wait(event string) {
+	for {
+		m, err := readDAP(c.r)
+		if err != nil {
+			fmt.Printf("\n %s", err)
+			return
+		}
+		c.show(m)
+		if m.Type == "event" && m.Event == event {
+			return
+		}
+	}
+}
+
+// Runs a session for the program src, script drives the client
+func testDAPSession(src string, script func(c *dapClient, path string)) {
+	fmt.Printf("\n Program:\n%s\n", src)
+	dir, err := os.MkdirTemp("", "imp-dap")
+	if err != nil {
+		fmt.Printf("\n %s\n", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prog.imp")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		fmt.Printf("\n %s\n", err)
+		return
+	}
+	toServer, fromClient := io.Pipe()
+	toClient, fromServer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		serveDAP(toServer, fromServer)
+		fromServer.Close()
+		close(done)
+	}()
+	c := &dapClient{r: bufio.NewReader(toClient), w: fromClient, dir: dir}
+	script(c, path)
+	fromClient.Close()
+	<-done
+	fmt.Printf("\n")
+}
+
+func testDAP() {
+	prog := "{varI:=0; varS:=0;\n while varI < 3 {\n  varS = varS+varI;\n  varI = varI+1\n };\n print varS\n}"
+	fmt.Printf("\n Test 42.1 - Debug Adapter Protocol - breakpoints, variables and evaluate \n")
+	testDAPSession(prog, func(c *dapClient, path string) {
+		c.request("initialize", dapObject{"adapterID": "imp"})
+		c.request("launch", dapObject{"program": path})
+		c.request("setBreakpoints", dapObject{"source": dapObject{"path": path}, "breakpoints": []dapObject{{"line": 4}, {"line": 5}}})
+		c.request("configurationDone", nil)
+		c.wait("stopped")
+		c.request("threads", nil)
+		c.request("stackTrace", dapObject{"threadId": dapThread})
+		c.request("scopes", dapObject{"frameId": dapFrame})
+		c.request("variables", dapObject{"variablesReference": dapLocals})
+		c.request("evaluate", dapObject{"expression": "varS+varI*2", "frameId": dapFrame})
+		c.request("evaluate", dapObject{"expression": "varS+true", "frameId": dapFrame})
+		c.request("continue", dapObject{"threadId": dapThread})
+		c.wait("stopped")
+		c.request("variables", dapObject{"variablesReference": dapLocals})
+		c.request("setBreakpoints", dapObject{"source": dapObject{"path": path}, "breakpoints": []dapObject{}})
+		c.request("continue", dapObject{"threadId": dapThread})
+		c.wait("terminated")
+		c.request("disconnect", nil)
+	})
+	fmt.Printf("\n Test 42.2 - Debug Adapter Protocol - stepping \n")
+	testDAPSession(prog, func(c *dapClient, path string) {
+		c.request("initialize", dapObject{"adapterID": "imp"})
+		c.request("launch", dapObject{"program": path, "stopOnEntry": true})
+		c.request("configurationDone", nil)
+		c.wait("stopped")
+		for _, step := range []string{"next", "next", "stepIn", "next", "stepOut", "next"} {
+			c.request(step, dapObject{"threadId": dapThread})
+			c.wait("stopped")
+			c.request("stackTrace", dapObject{"threadId": dapThread})
+		}
+		c.request("disconnect", nil)
+	})
+	fmt.Printf("\n Test 42.3 - Debug Adapter Protocol - errors and failing assertions \n")
+	testDAPSession("{varX:=2;\n assert varX < 2;\n print varX}", func(c *dapClient, path string) {
+		c.request("initialize", dapObject{"adapterID": "imp"})
+		c.request("launch", dapObject{"program": filepath.Join(filepath.Dir(path), "missing.imp")})
+		c.request("launch", dapObject{"program": path})
+		c.request("evaluate", dapObject{"expression": "varX"})
+		c.request("setBreakpoints", dapObject{"source": dapObject{"path": path}, "breakpoints": []dapObject{{"line": 3}}})
+		c.request("configurationDone", nil)
+		c.wait("terminated")
+		c.request("disconnect", nil)
+	})
+	fmt.Printf("\n Test 42.4 - Debug Adapter Protocol - evaluate a one-letter variable \n")
+	testDAPSession("{x:=3;\n y:=x+1;\n print y}", func(c *dapClient, path string) {
+		c.request("initialize", dapObject{"adapterID": "imp"})
+		c.request("launch", dapObject{"program": path, "stopOnEntry": true})
+		c.request("configurationDone", nil)
+		c.wait("stopped")
+		c.request("next", dapObject{"threadId": dapThread})
+		c.wait("stopped")
+		c.request("evaluate", dapObject{"expression": "x", "frameId": dapFrame})
+		c.request("evaluate", dapObject{"expression": "1+x", "frameId": dapFrame})
+		c.request("disconnect", nil)
+	})
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Debugger
+//
+// eval calls stmtHook before each Decl, Assign, Print, Assert, Assume, IfEl
+// and While with the span of the statement and the current ValState, While
+// once per evaluation of its condition. imp debug installs a hook that reads
+// commands whenever the program should stop: step stops before the next
+// statement, next before the next statement outside of the body of the
+// current one, continue only at breakpoints. A breakpoint on a line stops
+// before the first statement that starts on it, each time it runs, also
+// while stepping.
+
+var stmtHook func(s Stmt, sp Span, vals ValState)
+
+func beforeStmt(s Stmt, sp Span, vals ValState) {
+	if stmtHook != nil {
+		stmtHook(s, sp, vals)
+	}
+}
+
+type debugMode int
+
+const (
+	debugContinue debugMode = 0
+	debugStep     debugMode = 1
+	debugNext     debugMode = 2
+	debugOut      debugMode = 3
+)
+
+// Ends the program when the debugger quits
+type debugQuit struct{}
+
+type debugger struct {
+	in     *bufio.Scanner
+	out    io.Writer
+	echo   bool     // print the commands read, for scripted input
+	lines  []string // source lines, nil for a JSON program
+	breaks map[int]bool
+	first  map[int]Span // first statement starting on each line
+	depth  map[Span]int // nesting of each statement in loops and ifs
+	mode   debugMode
+	from   int // depth of the statement next or out was given at
+	last   string
+}
+
+func newDebugger(src string, b Block, in io.Reader, out io.Writer) *debugger {
+	d := &debugger{
+		in:     bufio.NewScanner(in),
+		out:    out,
+		breaks: map[int]bool{},
+		first:  map[int]Span{},
+		depth:  map[Span]int{},
+		mode:   debugStep,
+	}
+	if !isJSONProgram(src) {
+		d.lines = strings.Split(src, "\n")
+	}
+	var walk func(s Stmt, depth int)
+	walk = func(s Stmt, depth int) {
+		var sp Span
+		switch s := s.(type) {
+		case ComS:
+			walk(s[0], depth)
+			walk(s[1], depth)
+			return
+		case Decl:
+			sp = s.span
+		case Assign:
+			sp = s.span
+		case Print:
+			sp = s.span
+		case Assert:
+			sp = s.span
+		case Assume:
+			sp = s.span
+		case IfEl:
+			sp = s.span
+			walk(s.b1.s, depth+1)
+			walk(s.b2.s, depth+1)
+		case While:
+			sp = s.span
+			walk(s.b.s, depth+1)
+		default:
+			return
+		}
+		d.depth[sp] = depth
+		if f, ok := d.first[sp.line]; sp.line > 0 && (!ok || sp.col < f.col) {
+			d.first[sp.line] = sp
+		}
+	}
+	walk(b.s, 0)
+	return d
+}
+
+// Runs b under the debugger, returns the AssertionError of a failing assert
+func (d *debugger) run(b Block) error {
+	quit, err := runHooked(b, d.pause)
+	if !quit && err == nil {
+		fmt.Fprintf(d.out, "\nprogram finished\n")
+	}
+	return err
+}
+
+// Runs b with hook before each statement, quit is true if the hook ended it
+func runHooked(b Block, hook func(s Stmt, sp Span, vals ValState)) (quit bool, err error) {
+	stmtHook = hook
+	defer func() {
+		stmtHook = nil
+		if r := recover(); r != nil {
+			if _, ok := r.(debugQuit); !ok {
+				panic(r)
+			}
+			quit, err = true, nil
+		}
+	}()
+	return false, evalChecked(b, make(ValState))
+}
+
+// Why the program stops before the statement at sp, "" if it goes on
+func (d *debugger) stops(sp Span) string {
+	if d.breaks[sp.line] && d.first[sp.line] == sp {
+		return "breakpoint"
+	}
+	switch d.mode {
+	case debugContinue:
+		return ""
+	case debugNext:
+		if d.depth[sp] > d.from {
+			return ""
+		}
+	case debugOut:
+		if d.depth[sp] >= d.from {
+			return ""
+		}
+	}
+	return "step"
+}
+
+// Goes on from the statement at sp in mode
+func (d *debugger) resume(mode debugMode, sp Span) {
+	d.mode = mode
+	d.from = d.depth[sp]
+}
+
+func (d *debugger) pause(s Stmt, sp Span, vals ValState) {
+	switch d.stops(sp) {
+	case "":
+		return
+	case "breakpoint":
+		fmt.Fprintf(d.out, "\nbreakpoint at line %d", sp.line)
+	}
+	fmt.Fprintf(d.out, "\nstopped at %s: %s\n", sp.pretty(), stmtHead(s))
+	for {
+		fmt.Fprintf(d.out, "(imp) ")
+		if !d.in.Scan() {
+			fmt.Fprintf(d.out, "\n")
+			panic(debugQuit{})
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if d.echo {
+			fmt.Fprintf(d.out, "%s\n", line)
+		}
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+		if d.command(line, sp, vals) {
+			return
+		}
+	}
+}
+
+// Runs a command, true if the program should go on
+func (d *debugger) command(line string, sp Span, vals ValState) bool {
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case "":
+	case "step", "s":
+		d.resume(debugStep, sp)
+		return true
+	case "next", "n":
+		d.resume(debugNext, sp)
+		return true
+	case "continue", "c":
+		d.resume(debugContinue, sp)
+		return true
+	case "break", "b", "delete", "d":
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintf(d.out, "%s needs a line number\n", cmd)
+			break
+		}
+		if cmd == "delete" || cmd == "d" {
+			delete(d.breaks, n)
+			fmt.Fprintf(d.out, "deleted breakpoint at line %d\n", n)
+			break
+		}
+		if !d.setBreak(n) {
+			fmt.Fprintf(d.out, "no statement starts on line %d\n", n)
+			break
+		}
+		fmt.Fprintf(d.out, "breakpoint at line %d\n", n)
+	case "print", "p":
+		if arg == "" {
+			fmt.Fprintf(d.out, "%s\n", showState(vals))
+			break
+		}
+		e, v, err := evalExpression(arg, vals)
+		if err != nil {
+			fmt.Fprintf(d.out, "%s\n", err)
+			break
+		}
+		fmt.Fprintf(d.out, "%s = %s\n", e.pretty(), showVal(v))
+	case "list", "l":
+		d.list(sp)
+	case "quit", "q":
+		panic(debugQuit{})
+	case "help", "h":
+		fmt.Fprintf(d.out, "step, s        stop before the next statement\n")
+		fmt.Fprintf(d.out, "next, n        stop before the next statement outside of this one\n")
+		fmt.Fprintf(d.out, "continue, c    run to the next breakpoint\n")
+		fmt.Fprintf(d.out, "break, b N     set a breakpoint on line N\n")
+		fmt.Fprintf(d.out, "delete, d N    remove the breakpoint on line N\n")
+		fmt.Fprintf(d.out, "print, p [e]   print the variables or the value of e\n")
+		fmt.Fprintf(d.out, "list, l        print the program\n")
+		fmt.Fprintf(d.out, "quit, q        stop the program\n")
+	default:
+		fmt.Fprintf(d.out, "unknown command %s, try help\n", cmd)
+	}
+	return false
+}
+
+// Sets a breakpoint on line n, false if no statement starts on it
+func (d *debugger) setBreak(n int) bool {
+	if _, ok := d.first[n]; !ok {
+		return false
+	}
+	d.breaks[n] = true
+	return true
+}
+
+// The source lines, => marks the current line and * a breakpoint
+func (d *debugger) list(sp Span) {
+	if d.lines == nil {
+		fmt.Fprintf(d.out, "no source\n")
+		return
+	}
+	for i, l := range d.lines {
+		mark := "  "
+		if i+1 == sp.line {
+			mark = "=>"
+		}
+		brk := " "
+		if d.breaks[i+1] {
+			brk = "*"
+		}
+		fmt.Fprintf(d.out, "%s%s%3d  %s\n", mark, brk, i+1, l)
+	}
+}
+
+// Statement without its blocks
+func stmtHead(s Stmt) string {
+	switch s := s.(type) {
+	case While:
+		return "while " + s.e.pretty()
+	case IfEl:
+		return "if " + s.e.pretty()
+	}
+	return s.pretty()
+}
+
+// Variables in alphabetical order, x = v
+func showState(vals ValState) string {
+	if len(vals) == 0 {
+		return "no variables"
+	}
+	var names []string
+	for x := range vals {
+		names = append(names, x)
+	}
+	sort.Strings(names)
+	var xs []string
+	for _, x := range names {
+		xs = append(xs, x+" = "+showVal(vals[x]))
+	}
+	return strings.Join(xs, ", ")
+}
+
+// Parses src and evaluates it in vals, an error if it has no value
+func evalExpression(src string, vals ValState) (Exp, Val, error) {
+	ok, errorAt, e := parseExpression(src)
+	if !ok {
+		return nil, Val{}, fmt.Errorf("ERROR ON PARSE AT CHARACTER %d", errorAt)
+	}
+	t := TyState{}
+	for x, v := range vals {
+		if ty := valType(v); ty != TyIllTyped {
+			t[x] = ty
+		}
+	}
+	for _, x := range expVars(e) {
+		if _, ok := t[x]; !ok {
+			return e, Val{}, errors.New("unknown variable " + x)
+		}
+	}
+	if ty, _ := e.infer(t); ty == TyIllTyped {
+		return e, Val{}, errors.New("ill-typed expression " + e.pretty())
+	}
+	return e, e.eval(vals), nil
+}
+
+func valType(v Val) Type {
+	switch v.flag {
+	case ValueInt:
+		return TyInt
+	case ValueBool:
+		return TyBool
+	}
+	return TyIllTyped
+}
+
+func testDebugger(src string, commands string) {
+	fmt.Printf("\n Program:\n%s\n Commands: %s", src, strings.ReplaceAll(commands, "\n", "; "))
+	b, err := loadProgram(src)
+	if err != nil {
+		fmt.Printf("\n %s\n", err)
+		return
+	}
+	d := newDebugger(src, b, strings.NewReader(commands), os.Stdout)
+	d.echo = true
+	if err := d.run(b); err != nil {
+		fmt.Printf("\n%s\n", err)
+	}
+}
+
+func testDebug() {
+	prog := "{varI:=0; varS:=0;\n while varI < 3 {\n  varS = varS+varI;\n  varI = varI+1\n };\n print varS\n}"
+	fmt.Printf("\n Test 41.1 - Debugger - step and print \n")
+	testDebugger(prog, "step\ns\np\n\np varS+1\np varI < 3 && varS == 0\nc")
+	fmt.Printf("\n Test 41.2 - Debugger - breakpoints and continue \n")
+	testDebugger(prog, "b 4\nb 5\nb 6\nc\np\nc\nl\nd 4\nc\np varS")
+	fmt.Printf("\n Test 41.3 - Debugger - next steps over the body of a loop \n")
+	testDebugger(prog, "n\nn\nn\ns\nn\nn\nn\nn\nn\nn\np")
+	fmt.Printf("\n Test 41.4 - Debugger - errors, quit and failing assertions \n")
+	testDebugger(prog, "s\np varX\np varI+true\np varI+\nb 9\nfoo\nq")
+	testDebugger("{varX:=2;\n assert varX < 2;\n print varX}", "c")
+	fmt.Printf("\n Test 41.5 - Debugger - expressions ending in a one-letter variable \n")
+	testDebugger("{x:=3;\n y:=x*x;\n print y}", "n\nn\np x\np 1+x\np x*x == y\nc")
+}
@@ -0,0 +1,113 @@
+package main
+
+import "strings"
+
+// Definite assignment analysis
+//
+// Forward data-flow pass over a block proving that every variable read is
+// preceded by a declaration on all paths. A variable declared on some paths
+// only is "maybe declared", for it a path is kept on which it is not declared.
+// Variables not declared on any path are left to the type checker.
+
+type daState struct {
+	decl  map[string]bool
+	maybe map[string][]string
+}
+
+func newDaState() daState {
+	return daState{map[string]bool{}, map[string][]string{}}
+}
+
+func (d daState) copy() daState {
+	d2 := newDaState()
+	for x := range d.decl {
+		d2.decl[x] = true
+	}
+	for x, p := range d.maybe {
+		d2.maybe[x] = p
+	}
+	return d2
+}
+
+// Returns one message per read of a maybe undeclared variable
+func definiteAssignment(b Block) []string {
+	var errs []string
+	daBlock(b, newDaState(), &errs)
+	return errs
+}
+
+func daBlock(b Block, d daState, errs *[]string) daState {
+	return daStmt(b.s, d, errs)
+}
+
+func daStmt(s Stmt, d daState, errs *[]string) daState {
+	switch s := s.(type) {
+	case ComS:
+		d = daStmt(s[0], d, errs)
+		return daStmt(s[1], d, errs)
+	case Decl:
+		daUse(expVars(s.rhs), s.pretty(), d, errs)
+		d.decl[s.lhs] = true
+		delete(d.maybe, s.lhs)
+	case Assign:
+		daUse(append([]string{s.name}, expVars(s.value)...), s.pretty(), d, errs)
+	case Print:
+		daUse(expVars(s.e), s.pretty(), d, errs)
+	case Assert:
+		daUse(expVars(s.e), s.pretty(), d, errs)
+	case Assume:
+		daUse(expVars(s.e), s.pretty(), d, errs)
+	case IfEl:
+		c := s.e.pretty()
+		daUse(expVars(s.e), "if "+c, d, errs)
+		d1 := daBlock(s.b1, d.copy(), errs)
+		d2 := daBlock(s.b2, d.copy(), errs)
+		return daJoin(d1, d2, "then branch of if "+c, "else branch of if "+c)
+	case While:
+		c := s.e.pretty()
+		daUse(expVars(s.e), "while "+c, d, errs)
+		if s.inv != nil {
+			daUse(expVars(s.inv), "invariant "+s.inv.pretty(), d, errs)
+		}
+		d1 := daBlock(s.b, d.copy(), errs)
+		return daJoin(d, d1, "while "+c+" not entered", "body of while "+c)
+	}
+	return d
+}
+
+func daUse(names []string, where string, d daState, errs *[]string) {
+	seen := map[string]bool{}
+	for _, x := range names {
+		p, ok := d.maybe[x]
+		if !ok || seen[x] {
+			continue
+		}
+		seen[x] = true
+		*errs = append(*errs, x+" may be undeclared here: "+where+" (path: "+strings.Join(p, " -> ")+")")
+	}
+}
+
+// Join of the states at the end of two paths
+func daJoin(d1, d2 daState, path1, path2 string) daState {
+	d := newDaState()
+	names := map[string]bool{}
+	for _, st := range []daState{d1, d2} {
+		for x := range st.decl {
+			names[x] = true
+		}
+		for x := range st.maybe {
+			names[x] = true
+		}
+	}
+	for x := range names {
+		switch {
+		case d1.decl[x] && d2.decl[x]:
+			d.decl[x] = true
+		case !d1.decl[x]:
+			d.maybe[x] = append([]string{path1}, d1.maybe[x]...)
+		default:
+			d.maybe[x] = append([]string{path2}, d2.maybe[x]...)
+		}
+	}
+	return d
+}
@@ -0,0 +1,40 @@
+package main
+
+// Diagnostics reported by the checker and the analyses
+
+type Severity int
+
+const (
+	SeverityWarning Severity = 0
+	SeverityError   Severity = 1
+)
+
+type Diagnostic struct {
+	sev Severity
+	msg string
+}
+
+func warning(msg string) Diagnostic {
+	return Diagnostic{SeverityWarning, msg}
+}
+
+func errorDiag(msg string) Diagnostic {
+	return Diagnostic{SeverityError, msg}
+}
+
+func (d Diagnostic) pretty() string {
+	if d.sev == SeverityError {
+		return "error: " + d.msg
+	}
+	return "warning: " + d.msg
+}
+
+// True if one of the diagnostics fails the program, with werror warnings do
+func failed(ds []Diagnostic, werror bool) bool {
+	for _, d := range ds {
+		if d.sev == SeverityError || werror {
+			return true
+		}
+	}
+	return false
+}
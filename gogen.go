@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Go backend
+//
+// Translates a checked Block into a Go main package. IMP ints are Go ints,
+// so arithmetic behaves exactly like the interpreter. A variable becomes a
+// local of the innermost Go block it is visible in: variables declared in both
+// branches of an if are declared before the if, a declaration of a variable
+// already visible with the same type is an assignment, as ValState is flat.
+// A failing assert writes its message to stderr and exits with status 1.
+
+type goGen struct {
+	scopes  []map[typedVar]bool
+	prints  bool
+	asserts bool
+}
+
+func genGo(b Block) string {
+	g := &goGen{}
+	body := g.block(b, make(TyState))
+	var x string
+	x = "// Code generated by imp build -target=go. DO NOT EDIT.\n\n"
+	x += "package main\n\n"
+	switch {
+	case g.asserts:
+		x += "import (\n\"fmt\"\n\"os\"\n)\n\n"
+	case g.prints:
+		x += "import \"fmt\"\n\n"
+	}
+	x += "func main() {\n" + strings.Join(body, "\n") + "\n}\n"
+	src, err := format.Source([]byte(x))
+	if err != nil {
+		return x
+	}
+	return string(src)
+}
+
+func (g *goGen) visible(v typedVar) bool {
+	for _, sc := range g.scopes {
+		if sc[v] {
+			return true
+		}
+	}
+	return false
+}
+
+// Lines of a block, its own variables are declared first
+func (g *goGen) block(b Block, t TyState) []string {
+	g.scopes = append(g.scopes, map[typedVar]bool{})
+	var owned []typedVar
+	own := func(v typedVar) {
+		if !g.visible(v) {
+			g.scopes[len(g.scopes)-1][v] = true
+			owned = append(owned, v)
+		}
+	}
+	body := g.stmt(b.s, t, own)
+	g.scopes = g.scopes[:len(g.scopes)-1]
+	var lines []string
+	for _, v := range owned {
+		name := typedName(v.name, v.ty)
+		lines = append(lines, "var "+name+" "+goType(v.ty), "_ = "+name)
+	}
+	return append(lines, body...)
+}
+
+func goType(ty Type) string {
+	if ty == TyBool {
+		return "bool"
+	}
+	return "int"
+}
+
+func (g *goGen) stmt(s Stmt, t TyState, own func(typedVar)) []string {
+	switch s := s.(type) {
+	case ComS:
+		return append(g.stmt(s[0], t, own), g.stmt(s[1], t, own)...)
+	case Decl:
+		e := goExp(s.rhs, t)
+		v := typedVar{s.lhs, declare(s, t)}
+		own(v)
+		return []string{typedName(v.name, v.ty) + " = " + e}
+	case Assign:
+		return []string{typedName(s.name, t[s.name]) + " = " + goExp(s.value, t)}
+	case Print:
+		g.prints = true
+		return []string{"fmt.Println(" + goExp(s.e, t) + ")"}
+	case Assert:
+		g.asserts = true
+		return []string{
+			"if !" + goExp(s.e, t) + " {",
+			"fmt.Fprintln(os.Stderr, " + strconv.Quote(assertMessage(s)) + ")",
+			"os.Exit(1)",
+			"}",
+		}
+	case IfEl:
+		// The variables visible after the if must be declared before it
+		after := copyTyState(t)
+		typeStmt(s, after)
+		var xs []string
+		for x := range after {
+			xs = append(xs, x)
+		}
+		sort.Strings(xs)
+		for _, x := range xs {
+			own(typedVar{x, after[x]})
+		}
+		t1, t2 := copyTyState(t), copyTyState(t)
+		lines := []string{"if " + goExp(s.e, t) + " {"}
+		lines = append(lines, g.block(s.b1, t1)...)
+		lines = append(lines, "} else {")
+		lines = append(lines, g.block(s.b2, t2)...)
+		lines = append(lines, "}")
+		joinTyStates(t, t1, t2, "", "")
+		return lines
+	case While:
+		lines := []string{"for " + goExp(s.e, t) + " {"}
+		lines = append(lines, g.block(s.b, copyTyState(t))...)
+		return append(lines, "}")
+	}
+	return nil
+}
+
+func goExp(e Exp, t TyState) string {
+	switch e := e.(type) {
+	case Num:
+		return fmt.Sprintf("%d", int(e))
+	case Bool:
+		return e.pretty()
+	case Var:
+		return typedName(string(e), t[string(e)])
+	case Neg:
+		return "!" + goExp(e[0], t)
+	}
+	op := map[string]string{
+		"Plus": "+", "Minus": "-", "Mult": "*", "And": "&&", "Or": "||",
+		"Equ": "==", "Neq": "!=", "Les": "<", "Leq": "<=", "Gre": ">", "Geq": ">=",
+	}[kindOf(e)]
+	c := expChildren(e)
+	return "(" + goExp(c[0], t) + " " + op + " " + goExp(c[1], t) + ")"
+}
+
+func runGo(b Block) (string, error) {
+	return runInTempDir(map[string]string{"prog.go": genGo(b)},
+		[]string{"go", "run", "prog.go"})
+}
+
+func testGoBackend() {
+	fmt.Printf("\n Test 29.1 - Go Backend - generated code \n")
+	src := "{varX:=true; if varX {varY:=1} else {varY:=2}; while varY<3 {print varY; varY = varY+1}}"
+	_, _, b := parse(src)
+	fmt.Printf("\n Input: %s\n\n%s", src, genGo(b))
+	fmt.Printf("\n Test 29.2 - Go Backend - go run against the interpreter \n")
+	if !haveTools("go") {
+		fmt.Printf("\n go not found, skipped \n")
+		return
+	}
+	testBackend("GO BACKEND", runGo)
+}
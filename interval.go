@@ -0,0 +1,478 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Interval analysis
+//
+// Abstract interpretation of a checked Block: every variable is mapped to an
+// interval of the values it may have, bools to an interval of 0 and 1. The
+// bounds are int values, an interval reaching math.MinInt or math.MaxInt
+// means unbounded. Arithmetic is done exactly; a result partly outside the
+// range of int wraps around at runtime and becomes unbounded, a result
+// entirely outside of it is a guaranteed overflow. IfEl and While refine the
+// intervals by their conditions. At the head of While the intervals are
+// widened to unbounded until they are stable, then narrowed twice.
+//
+// Warnings are only reported on the last pass over a loop body, when the
+// intervals at its head are stable. IMP has no division, so there is no
+// check for a division by zero.
+
+type Interval struct {
+	lo, hi  int
+	boolean bool
+}
+
+// nil is the state of unreachable code
+type IntervalState map[string]Interval
+
+var topInterval = Interval{lo: math.MinInt, hi: math.MaxInt}
+
+func point(v int) Interval {
+	return Interval{lo: v, hi: v}
+}
+
+func boolInterval(lo, hi bool) Interval {
+	i := Interval{boolean: true}
+	if lo {
+		i.lo = 1
+	}
+	if hi {
+		i.hi = 1
+	}
+	return i
+}
+
+func (i Interval) pretty() string {
+	if i.boolean {
+		switch {
+		case i.lo == 1:
+			return "true"
+		case i.hi == 0:
+			return "false"
+		}
+		return "bool"
+	}
+	if i.lo == i.hi {
+		return fmt.Sprint(i.lo)
+	}
+	lo, hi := fmt.Sprint(i.lo), fmt.Sprint(i.hi)
+	if i.lo == math.MinInt {
+		lo = "-inf"
+	}
+	if i.hi == math.MaxInt {
+		hi = "+inf"
+	}
+	return "[" + lo + ", " + hi + "]"
+}
+
+func (i Interval) join(j Interval) Interval {
+	return Interval{min(i.lo, j.lo), max(i.hi, j.hi), i.boolean}
+}
+
+// Bounds that grew since old become unbounded
+func (old Interval) widen(i Interval) Interval {
+	if i.lo < old.lo {
+		i.lo = math.MinInt
+	}
+	if i.hi > old.hi {
+		i.hi = math.MaxInt
+	}
+	if old.boolean {
+		i.lo, i.hi = max(i.lo, 0), min(i.hi, 1)
+	}
+	return i
+}
+
+func (st IntervalState) copy() IntervalState {
+	if st == nil {
+		return nil
+	}
+	c := IntervalState{}
+	for x, i := range st {
+		c[x] = i
+	}
+	return c
+}
+
+func (st IntervalState) join(st2 IntervalState) IntervalState {
+	if st == nil {
+		return st2.copy()
+	}
+	if st2 == nil {
+		return st.copy()
+	}
+	j := IntervalState{}
+	for x, i := range st {
+		if i2, ok := st2[x]; ok {
+			j[x] = i.join(i2)
+		}
+	}
+	return j
+}
+
+func (st IntervalState) widen(st2 IntervalState) IntervalState {
+	if st == nil || st2 == nil {
+		return st.join(st2)
+	}
+	w := IntervalState{}
+	for x, i := range st2 {
+		if old, ok := st[x]; ok {
+			w[x] = old.widen(i)
+		}
+	}
+	return w
+}
+
+func (st IntervalState) equal(st2 IntervalState) bool {
+	if (st == nil) != (st2 == nil) || len(st) != len(st2) {
+		return false
+	}
+	for x, i := range st {
+		if st2[x] != i {
+			return false
+		}
+	}
+	return true
+}
+
+func (st IntervalState) pretty() string {
+	if st == nil {
+		return "unreachable"
+	}
+	var xs []string
+	for x := range st {
+		xs = append(xs, x)
+	}
+	sort.Strings(xs)
+	var parts []string
+	for _, x := range xs {
+		parts = append(parts, x+": "+st[x].pretty())
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// Program point, the state before a statement or at the head of a loop
+type IntervalPoint struct {
+	span  Span
+	stmt  string
+	state IntervalState
+}
+
+type intervalAnalysis struct {
+	report bool // last pass, points and warnings are recorded
+	points []IntervalPoint
+	ds     []Diagnostic
+}
+
+// Intervals at every program point of b and warnings about overflows and
+// comparisons with a constant result
+func intervals(b Block) ([]IntervalPoint, []Diagnostic) {
+	a := &intervalAnalysis{report: true}
+	end := a.stmt(b.s, IntervalState{})
+	a.points = append(a.points, IntervalPoint{span: Span{line: b.span.endLine, col: b.span.endCol}, stmt: "end", state: end})
+	return a.points, a.ds
+}
+
+func (a *intervalAnalysis) warn(msg string) {
+	if a.report {
+		for _, d := range a.ds {
+			if d.msg == msg {
+				return
+			}
+		}
+		a.ds = append(a.ds, warning(msg))
+	}
+}
+
+func (a *intervalAnalysis) record(span Span, label string, st IntervalState) {
+	if a.report {
+		a.points = append(a.points, IntervalPoint{span, label, st.copy()})
+	}
+}
+
+func (a *intervalAnalysis) stmt(s Stmt, st IntervalState) IntervalState {
+	switch s := s.(type) {
+	case ComS:
+		return a.stmt(s[1], a.stmt(s[0], st))
+	case Decl:
+		a.record(s.span, s.pretty(), st)
+		if st == nil {
+			return nil
+		}
+		st = st.copy()
+		st[s.lhs] = a.exp(s.rhs, st)
+		return st
+	case Assign:
+		a.record(s.span, s.pretty(), st)
+		if st == nil {
+			return nil
+		}
+		st = st.copy()
+		st[s.name] = a.exp(s.value, st)
+		return st
+	case Print:
+		a.record(s.span, s.pretty(), st)
+		if st != nil {
+			a.exp(s.e, st)
+		}
+		return st
+	case Assert:
+		// Execution only goes on if the assertion holds
+		a.record(s.span, s.pretty(), st)
+		if st != nil {
+			a.condition("assert", s.e, st)
+		}
+		return refine(s.e, true, st)
+	case Assume:
+		a.record(s.span, s.pretty(), st)
+		return refine(s.e, true, st)
+	case IfEl:
+		a.record(s.span, "if "+s.e.pretty(), st)
+		if st == nil {
+			a.stmt(s.b1.s, nil)
+			a.stmt(s.b2.s, nil)
+			return nil
+		}
+		a.condition("if", s.e, st)
+		st1 := a.stmt(s.b1.s, refine(s.e, true, st))
+		st2 := a.stmt(s.b2.s, refine(s.e, false, st))
+		return st1.join(st2)
+	case While:
+		report := a.report
+		a.report = false
+		head := st
+		for i := 0; ; i++ {
+			next := st.join(a.stmt(s.b.s, refine(s.e, true, head)))
+			if i > 0 {
+				next = head.widen(next)
+			}
+			if next.equal(head) {
+				break
+			}
+			head = next
+		}
+		for i := 0; i < 2; i++ {
+			head = st.join(a.stmt(s.b.s, refine(s.e, true, head)))
+		}
+		a.report = report
+		a.record(s.span, "while "+s.e.pretty(), head)
+		if head != nil {
+			a.condition("while", s.e, head)
+		}
+		a.stmt(s.b.s, refine(s.e, true, head))
+		return refine(s.e, false, head)
+	}
+	return st
+}
+
+// Warns about comparisons in a condition whose result is known
+func (a *intervalAnalysis) condition(stmt string, e Exp, st IntervalState) {
+	// Constant conditions like 1 < 2 are reported by lint
+	if _, ok := optExp(e).(Bool); ok {
+		return
+	}
+	var walk func(e Exp)
+	walk = func(e Exp) {
+		switch e.(type) {
+		case Les, Leq, Gre, Geq, Equ, Neq:
+			if i := a.exp(e, st); i.lo == i.hi {
+				a.warn(fmt.Sprintf("comparison %s in condition of %s is always %t", e.pretty(), stmt, i.lo == 1))
+			}
+			return
+		}
+		for _, c := range expChildren(e) {
+			walk(c)
+		}
+	}
+	walk(e)
+}
+
+// Interval of a result computed exactly, x and y are the bounds of the
+// operands combined with op
+func (a *intervalAnalysis) arith(e Exp, op func(x, y *big.Int) *big.Int, i, j Interval) Interval {
+	var lo, hi *big.Int
+	for _, x := range []int{i.lo, i.hi} {
+		for _, y := range []int{j.lo, j.hi} {
+			v := op(big.NewInt(int64(x)), big.NewInt(int64(y)))
+			if lo == nil || v.Cmp(lo) < 0 {
+				lo = v
+			}
+			if hi == nil || v.Cmp(hi) > 0 {
+				hi = v
+			}
+		}
+	}
+	minInt, maxInt := big.NewInt(math.MinInt), big.NewInt(math.MaxInt)
+	switch {
+	case lo.Cmp(maxInt) > 0 || hi.Cmp(minInt) < 0:
+		a.warn("overflow: " + e.pretty() + " is always outside of the range of int")
+		return topInterval
+	case lo.Cmp(minInt) < 0 || hi.Cmp(maxInt) > 0:
+		return topInterval
+	}
+	return Interval{lo: int(lo.Int64()), hi: int(hi.Int64())}
+}
+
+func (a *intervalAnalysis) exp(e Exp, st IntervalState) Interval {
+	switch e := e.(type) {
+	case Num:
+		return point(int(e))
+	case Bool:
+		return boolInterval(bool(e), bool(e))
+	case Var:
+		if i, ok := st[string(e)]; ok {
+			return i
+		}
+		return topInterval
+	case Plus:
+		return a.arith(e, func(x, y *big.Int) *big.Int { return new(big.Int).Add(x, y) }, a.exp(e[0], st), a.exp(e[1], st))
+	case Minus:
+		return a.arith(e, func(x, y *big.Int) *big.Int { return new(big.Int).Sub(x, y) }, a.exp(e[0], st), a.exp(e[1], st))
+	case Mult:
+		return a.arith(e, func(x, y *big.Int) *big.Int { return new(big.Int).Mul(x, y) }, a.exp(e[0], st), a.exp(e[1], st))
+	case Neg:
+		i := a.exp(e[0], st)
+		return Interval{1 - i.hi, 1 - i.lo, true}
+	case And:
+		i, j := a.exp(e[0], st), a.exp(e[1], st)
+		return Interval{min(i.lo, j.lo), min(i.hi, j.hi), true}
+	case Or:
+		i, j := a.exp(e[0], st), a.exp(e[1], st)
+		return Interval{max(i.lo, j.lo), max(i.hi, j.hi), true}
+	case Les:
+		return compare(a.exp(e[0], st), a.exp(e[1], st), false)
+	case Leq:
+		return compare(a.exp(e[0], st), a.exp(e[1], st), true)
+	case Gre:
+		return compare(a.exp(e[1], st), a.exp(e[0], st), false)
+	case Geq:
+		return compare(a.exp(e[1], st), a.exp(e[0], st), true)
+	case Equ:
+		i, j := a.exp(e[0], st), a.exp(e[1], st)
+		switch {
+		case i.lo == i.hi && i == j:
+			return boolInterval(true, true)
+		case i.hi < j.lo || j.hi < i.lo:
+			return boolInterval(false, false)
+		}
+		return boolInterval(false, true)
+	case Neq:
+		return a.exp(Neg{Equ{e[0], e[1]}}, st)
+	}
+	return topInterval
+}
+
+// i < j, or i <= j with orEqual
+func compare(i, j Interval, orEqual bool) Interval {
+	switch {
+	case i.hi < j.lo || (orEqual && i.hi == j.lo):
+		return boolInterval(true, true)
+	case i.lo > j.hi || (!orEqual && i.lo == j.hi):
+		return boolInterval(false, false)
+	}
+	return boolInterval(false, true)
+}
+
+// State in which e has the value want, nil if e can never have it
+func refine(e Exp, want bool, st IntervalState) IntervalState {
+	if st == nil {
+		return nil
+	}
+	a := &intervalAnalysis{}
+	v := a.exp(e, st)
+	if (want && v.hi == 0) || (!want && v.lo == 1) {
+		return nil
+	}
+	st = st.copy()
+	switch e := e.(type) {
+	case Var:
+		st[string(e)] = boolInterval(want, want)
+	case Neg:
+		return refine(e[0], !want, st)
+	case And:
+		if want {
+			return refine(e[1], true, refine(e[0], true, st))
+		}
+	case Or:
+		if !want {
+			return refine(e[1], false, refine(e[0], false, st))
+		}
+	case Les:
+		return refineLess(e[0], e[1], want, false, st)
+	case Leq:
+		return refineLess(e[0], e[1], want, true, st)
+	case Gre:
+		return refineLess(e[1], e[0], want, false, st)
+	case Geq:
+		return refineLess(e[1], e[0], want, true, st)
+	}
+	return st
+}
+
+// Refines the variables of x < y (x <= y with orEqual), for want false the
+// condition is y <= x (y < x)
+func refineLess(x, y Exp, want, orEqual bool, st IntervalState) IntervalState {
+	if !want {
+		return refineLess(y, x, true, !orEqual, st)
+	}
+	a := &intervalAnalysis{}
+	i, j := a.exp(x, st), a.exp(y, st)
+	d := 1
+	if orEqual {
+		d = 0
+	}
+	// x <= j.hi - d and y >= i.lo + d, without going past the range of int
+	if v, ok := x.(Var); ok && j.hi-d <= j.hi {
+		i.hi = min(i.hi, j.hi-d)
+		if i.hi < i.lo {
+			return nil
+		}
+		st[string(v)] = i
+	}
+	if v, ok := y.(Var); ok && i.lo+d >= i.lo {
+		j.lo = max(j.lo, i.lo+d)
+		if j.hi < j.lo {
+			return nil
+		}
+		st[string(v)] = j
+	}
+	return st
+}
+
+func testIntervalProgram(s string) {
+	stmt, errorAt, b := parse(s)
+	fmt.Printf("\n Input: %s", s)
+	if !stmt {
+		fmt.Printf("\n ERROR ON PARSE \n AT CHARACTER %d \n", errorAt)
+		return
+	}
+	points, ds := intervals(b)
+	for _, p := range points {
+		fmt.Printf("\n %-6s %-22s %s", fmt.Sprintf("%d:%d", p.span.line, p.span.col), p.stmt, p.state.pretty())
+	}
+	fmt.Printf("\n Warnings: %d", len(ds))
+	for _, d := range ds {
+		fmt.Printf("\n %s", d.pretty())
+	}
+	fmt.Printf("\n")
+}
+
+func testIntervals() {
+	fmt.Printf("\n Test 36.1 - Intervals - widening and narrowing at the head of while \n")
+	testIntervalProgram("{varX:=0; while varX<9 {varX = varX+1}; print varX}")
+	fmt.Printf("\n Test 36.2 - Intervals - branches refine and join \n")
+	testIntervalProgram("{varX:=3; varY:=0; if varX<5 {varY = varX*2} else {varY = 1}; print varY}")
+	fmt.Printf("\n Test 36.3 - Intervals - comparison always true or false \n")
+	testIntervalProgram("{varX:=0; varN:=5; while varX<varN {if varX<7 {print varX} else {print 0}; varX = varX+1}}")
+	testIntervalProgram("{varB:=true; varX:=1; if varB {varX = 2} else {varX = 3}; if 4<varX {print varX} else {print 0}}")
+	fmt.Printf("\n Test 36.4 - Intervals - guaranteed overflow \n")
+	testIntervalProgram("{varX:=9*9*9*9*9*9*9*9*9; varY:=varX*varX*varX; print varY}")
+	fmt.Printf("\n Test 36.5 - Intervals - an overflow that may happen is not reported \n")
+	testIntervalProgram("{varX:=1; while 0<varX {varX = varX*9}; print varX}")
+}
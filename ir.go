@@ -0,0 +1,559 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Intermediate representation
+//
+// A checked Block is lowered to three-address instructions over virtual
+// registers, grouped in basic blocks. The IR is in SSA form: every register is
+// defined once. A variable is mapped to the register holding its current
+// value; where control flow joins (after IfEl, at the head of While) a phi
+// instruction selects the value by the predecessor block. Phis whose
+// arguments are all the same value are removed again.
+//
+// A block ends with ret (no successor), jmp (one) or br cond (two, the first
+// is taken if cond is true). Block 0 is the entry. assert stops the program
+// with its message if its argument is false.
+
+// A virtual register or a constant, bools are 0 and 1
+type Operand struct {
+	reg int // 0 for a constant
+	val int
+	ty  Type
+}
+
+type Instr struct {
+	op   string // copy add sub mul and or not eq ne lt le gt ge print assert phi
+	dst  int    // defined register, 0 for print and assert
+	args []Operand
+	from []int  // phi: predecessor block of every argument
+	msg  string // assert: error if the argument is false
+}
+
+type IRBlock struct {
+	id     int
+	instrs []*Instr
+	cond   Operand
+	succ   []int
+}
+
+type IRFunc struct {
+	blocks []*IRBlock
+	types  []Type   // type of every register, register 0 is unused
+	names  []string // variable a register holds a value of, "" for temporaries
+}
+
+// Operations of the binary expressions
+var irOps = map[string]string{
+	"Plus": "add", "Minus": "sub", "Mult": "mul", "And": "and", "Or": "or",
+	"Equ": "eq", "Neq": "ne", "Les": "lt", "Leq": "le", "Gre": "gt", "Geq": "ge",
+}
+
+func constant(v int, ty Type) Operand {
+	return Operand{val: v, ty: ty}
+}
+
+func (f *IRFunc) newReg(ty Type, name string) int {
+	f.types = append(f.types, ty)
+	f.names = append(f.names, name)
+	return len(f.types) - 1
+}
+
+func (f *IRFunc) newBlock() *IRBlock {
+	bb := &IRBlock{id: len(f.blocks)}
+	f.blocks = append(f.blocks, bb)
+	return bb
+}
+
+func (f *IRFunc) reg(r int) Operand {
+	return Operand{reg: r, ty: f.types[r]}
+}
+
+// Predecessors of every block, indexed by block id
+func (f *IRFunc) preds() [][]int {
+	p := make([][]int, len(f.blocks))
+	for _, bb := range f.blocks {
+		for _, s := range bb.succ {
+			p[s] = append(p[s], bb.id)
+		}
+	}
+	return p
+}
+
+// Lowering
+
+type irBuilder struct {
+	f   *IRFunc
+	cur *IRBlock
+}
+
+// Current value of every visible variable
+type irEnv map[typedVar]Operand
+
+func (e irEnv) copy() irEnv {
+	c := irEnv{}
+	for k, v := range e {
+		c[k] = v
+	}
+	return c
+}
+
+// Keys in a fixed order, so that the IR does not depend on map order
+func (e irEnv) keys() []typedVar {
+	var ks []typedVar
+	for k := range e {
+		ks = append(ks, k)
+	}
+	sort.Slice(ks, func(i, j int) bool {
+		if ks[i].name != ks[j].name {
+			return ks[i].name < ks[j].name
+		}
+		return ks[i].ty < ks[j].ty
+	})
+	return ks
+}
+
+func buildIR(b Block) *IRFunc {
+	f := &IRFunc{types: []Type{TyIllTyped}, names: []string{""}}
+	g := &irBuilder{f: f, cur: f.newBlock()}
+	g.stmt(b.s, make(TyState), irEnv{})
+	f.removeTrivialPhis()
+	return f
+}
+
+func (g *irBuilder) emit(op string, ty Type, name string, args ...Operand) Operand {
+	r := g.f.newReg(ty, name)
+	g.cur.instrs = append(g.cur.instrs, &Instr{op: op, dst: r, args: args})
+	return g.f.reg(r)
+}
+
+func (g *irBuilder) stmt(s Stmt, t TyState, env irEnv) {
+	switch s := s.(type) {
+	case ComS:
+		g.stmt(s[0], t, env)
+		g.stmt(s[1], t, env)
+	case Decl:
+		v := g.exp(s.rhs, t, env)
+		ty := declare(s, t)
+		env[typedVar{s.lhs, ty}] = g.emit("copy", ty, s.lhs, v)
+	case Assign:
+		v := g.exp(s.value, t, env)
+		ty := t[s.name]
+		env[typedVar{s.name, ty}] = g.emit("copy", ty, s.name, v)
+	case Print:
+		v := g.exp(s.e, t, env)
+		g.cur.instrs = append(g.cur.instrs, &Instr{op: "print", args: []Operand{v}})
+	case Assert:
+		v := g.exp(s.e, t, env)
+		g.cur.instrs = append(g.cur.instrs, &Instr{op: "assert", args: []Operand{v}, msg: assertMessage(s)})
+	case IfEl:
+		g.cur.cond = g.exp(s.e, t, env)
+		then, els := g.f.newBlock(), g.f.newBlock()
+		g.cur.succ = []int{then.id, els.id}
+		t1, t2 := copyTyState(t), copyTyState(t)
+		env1, env2 := env.copy(), env.copy()
+		g.cur = then
+		g.stmt(s.b1.s, t1, env1)
+		end1 := g.cur
+		g.cur = els
+		g.stmt(s.b2.s, t2, env2)
+		end2 := g.cur
+		join := g.f.newBlock()
+		end1.succ = []int{join.id}
+		end2.succ = []int{join.id}
+		g.cur = join
+		joinTyStates(t, t1, t2, "", "")
+		for k := range env {
+			delete(env, k)
+		}
+		for x, ty := range t {
+			env[typedVar{x, ty}] = Operand{}
+		}
+		for _, k := range env.keys() {
+			v1, v2 := env1[k], env2[k]
+			if v1 == v2 {
+				env[k] = v1
+				continue
+			}
+			r := g.f.newReg(k.ty, k.name)
+			join.instrs = append(join.instrs, &Instr{op: "phi", dst: r,
+				args: []Operand{v1, v2}, from: []int{end1.id, end2.id}})
+			env[k] = g.f.reg(r)
+		}
+	case While:
+		pre := g.cur
+		head := g.f.newBlock()
+		pre.succ = []int{head.id}
+		// A phi for every variable, the ones not assigned in the body are
+		// trivial and removed later
+		phis := map[typedVar]*Instr{}
+		for _, k := range env.keys() {
+			r := g.f.newReg(k.ty, k.name)
+			phi := &Instr{op: "phi", dst: r, args: []Operand{env[k]}, from: []int{pre.id}}
+			head.instrs = append(head.instrs, phi)
+			phis[k] = phi
+			env[k] = g.f.reg(r)
+		}
+		g.cur = head
+		head.cond = g.exp(s.e, t, env)
+		body, exit := g.f.newBlock(), g.f.newBlock()
+		head.succ = []int{body.id, exit.id}
+		g.cur = body
+		envB := env.copy()
+		g.stmt(s.b.s, copyTyState(t), envB)
+		g.cur.succ = []int{head.id}
+		for k, phi := range phis {
+			v, ok := envB[k]
+			if !ok {
+				v = env[k]
+			}
+			phi.args = append(phi.args, v)
+			phi.from = append(phi.from, g.cur.id)
+		}
+		g.cur = exit
+	}
+}
+
+func (g *irBuilder) exp(e Exp, t TyState, env irEnv) Operand {
+	switch e := e.(type) {
+	case Num:
+		return constant(int(e), TyInt)
+	case Bool:
+		if e {
+			return constant(1, TyBool)
+		}
+		return constant(0, TyBool)
+	case Var:
+		x := string(e)
+		return env[typedVar{x, t[x]}]
+	case Neg:
+		return g.emit("not", TyBool, "", g.exp(e[0], t, env))
+	}
+	ty, _ := e.infer(t)
+	c := expChildren(e)
+	x := g.exp(c[0], t, env)
+	y := g.exp(c[1], t, env)
+	return g.emit(irOps[kindOf(e)], ty, "", x, y)
+}
+
+// Replaces every use of register r by o
+func (f *IRFunc) replace(r int, o Operand) {
+	for _, bb := range f.blocks {
+		for _, in := range bb.instrs {
+			for i, a := range in.args {
+				if a.reg == r {
+					in.args[i] = o
+				}
+			}
+		}
+		if bb.cond.reg == r {
+			bb.cond = o
+		}
+	}
+}
+
+// Removes phis that select the same value on every edge (or themselves)
+func (f *IRFunc) removeTrivialPhis() {
+	for changed := true; changed; {
+		changed = false
+		for _, bb := range f.blocks {
+			for i, in := range bb.instrs {
+				if in.op != "phi" {
+					continue
+				}
+				var same *Operand
+				trivial := true
+				for j, a := range in.args {
+					if a.reg == in.dst && a.reg != 0 {
+						continue
+					}
+					if same != nil && *same != a {
+						trivial = false
+						break
+					}
+					same = &in.args[j]
+				}
+				if !trivial || same == nil {
+					continue
+				}
+				v := *same
+				bb.instrs = append(bb.instrs[:i], bb.instrs[i+1:]...)
+				f.replace(in.dst, v)
+				changed = true
+				break
+			}
+		}
+	}
+}
+
+// Checks the SSA properties: every register is defined once, every register
+// used is defined, phis have one argument per predecessor
+func (f *IRFunc) verify() error {
+	defined := map[int]bool{}
+	for _, bb := range f.blocks {
+		for _, in := range bb.instrs {
+			if in.dst == 0 {
+				continue
+			}
+			if defined[in.dst] {
+				return fmt.Errorf("%s defined twice", f.regName(in.dst))
+			}
+			defined[in.dst] = true
+		}
+	}
+	preds := f.preds()
+	for _, bb := range f.blocks {
+		for _, in := range bb.instrs {
+			for _, a := range in.args {
+				if a.reg != 0 && !defined[a.reg] {
+					return fmt.Errorf("b%d: %s used but not defined", bb.id, f.regName(a.reg))
+				}
+			}
+			if in.op != "phi" {
+				continue
+			}
+			p := append([]int(nil), preds[bb.id]...)
+			q := append([]int(nil), in.from...)
+			sort.Ints(p)
+			sort.Ints(q)
+			if fmt.Sprint(p) != fmt.Sprint(q) {
+				return fmt.Errorf("b%d: phi %s has arguments for %v, predecessors are %v", bb.id, f.regName(in.dst), q, p)
+			}
+		}
+		if len(bb.succ) == 2 && bb.cond.reg != 0 && !defined[bb.cond.reg] {
+			return fmt.Errorf("b%d: condition %s not defined", bb.id, f.regName(bb.cond.reg))
+		}
+	}
+	return nil
+}
+
+// Textual dump
+
+// Registers holding a variable are named after it, temporaries t<n>
+func (f *IRFunc) regName(r int) string {
+	if f.names[r] != "" {
+		return f.names[r] + "." + strconv.Itoa(r)
+	}
+	return "t" + strconv.Itoa(r)
+}
+
+func (f *IRFunc) operand(o Operand) string {
+	switch {
+	case o.reg != 0:
+		return f.regName(o.reg)
+	case o.ty == TyBool:
+		return strconv.FormatBool(o.val != 0)
+	}
+	return strconv.Itoa(o.val)
+}
+
+func (f *IRFunc) instr(in *Instr) string {
+	var args []string
+	for i, a := range in.args {
+		if in.op == "phi" {
+			args = append(args, fmt.Sprintf("[b%d: %s]", in.from[i], f.operand(a)))
+		} else {
+			args = append(args, f.operand(a))
+		}
+	}
+	switch in.op {
+	case "print", "assert":
+		return in.op + " " + args[0]
+	case "copy":
+		return f.regName(in.dst) + " = " + args[0]
+	}
+	return f.regName(in.dst) + " = " + in.op + " " + strings.Join(args, ", ")
+}
+
+func (f *IRFunc) pretty() string {
+	var x string
+	for _, bb := range f.blocks {
+		x += fmt.Sprintf("b%d:\n", bb.id)
+		for _, in := range bb.instrs {
+			x += "  " + f.instr(in) + "\n"
+		}
+		switch len(bb.succ) {
+		case 0:
+			x += "  ret\n"
+		case 1:
+			x += fmt.Sprintf("  jmp b%d\n", bb.succ[0])
+		default:
+			x += fmt.Sprintf("  br %s, b%d, b%d\n", f.operand(bb.cond), bb.succ[0], bb.succ[1])
+		}
+	}
+	return x
+}
+
+// Interpreter
+
+// Runs the IR, print writes in the format of Print.eval. Phis of a block are
+// evaluated together with the values of the block control came from.
+func (f *IRFunc) run(w io.Writer) error {
+	if len(f.blocks) == 0 {
+		return errors.New("no blocks")
+	}
+	regs := make([]int, len(f.types))
+	val := func(o Operand) int {
+		if o.reg == 0 {
+			return o.val
+		}
+		return regs[o.reg]
+	}
+	pred := -1
+	bb := f.blocks[0]
+	for {
+		var phiDst, phiVal []int
+		for _, in := range bb.instrs {
+			if in.op != "phi" {
+				continue
+			}
+			found := false
+			for i, p := range in.from {
+				if p == pred {
+					phiDst = append(phiDst, in.dst)
+					phiVal = append(phiVal, val(in.args[i]))
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("b%d: phi %s has no argument for b%d", bb.id, f.regName(in.dst), pred)
+			}
+		}
+		for i, r := range phiDst {
+			regs[r] = phiVal[i]
+		}
+		for _, in := range bb.instrs {
+			var a, b int
+			if len(in.args) > 0 {
+				a = val(in.args[0])
+			}
+			if len(in.args) > 1 {
+				b = val(in.args[1])
+			}
+			switch in.op {
+			case "phi":
+				continue
+			case "print":
+				if in.args[0].ty == TyBool {
+					fmt.Fprintf(w, "\n %t", a != 0)
+				} else {
+					fmt.Fprintf(w, "\n %d", a)
+				}
+				continue
+			case "assert":
+				if a == 0 {
+					return errors.New(in.msg)
+				}
+				continue
+			}
+			v, ok := irEval(in.op, a, b)
+			if !ok {
+				return fmt.Errorf("unknown instruction %s", in.op)
+			}
+			regs[in.dst] = v
+		}
+		pred = bb.id
+		switch len(bb.succ) {
+		case 0:
+			return nil
+		case 1:
+			bb = f.blocks[bb.succ[0]]
+		default:
+			if val(bb.cond) != 0 {
+				bb = f.blocks[bb.succ[0]]
+			} else {
+				bb = f.blocks[bb.succ[1]]
+			}
+		}
+	}
+}
+
+// Value of an instruction without side effects, false for phi, print and assert
+func irEval(op string, a, b int) (int, bool) {
+	b2i := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	switch op {
+	case "copy":
+		return a, true
+	case "not":
+		return 1 - a, true
+	case "add":
+		return a + b, true
+	case "sub":
+		return a - b, true
+	case "mul":
+		return a * b, true
+	case "and":
+		return a & b, true
+	case "or":
+		return a | b, true
+	case "eq":
+		return b2i(a == b), true
+	case "ne":
+		return b2i(a != b), true
+	case "lt":
+		return b2i(a < b), true
+	case "le":
+		return b2i(a <= b), true
+	case "gt":
+		return b2i(a > b), true
+	case "ge":
+		return b2i(a >= b), true
+	}
+	return 0, false
+}
+
+func runIR(b Block) (string, error) {
+	var buf strings.Builder
+	f := buildIR(b)
+	if err := f.verify(); err != nil {
+		return "", err
+	}
+	err := f.run(&buf)
+	return buf.String(), err
+}
+
+func testIRProgram(s string) {
+	stmt, errorAt, b := parse(s)
+	fmt.Printf("\n Input: %s", s)
+	if !stmt {
+		fmt.Printf("\n ERROR ON PARSE \n AT CHARACTER %d \n", errorAt)
+		return
+	}
+	f := buildIR(b)
+	fmt.Printf("\n\n%s", f.pretty())
+	if err := f.verify(); err != nil {
+		fmt.Printf(" Verify: %v \n", err)
+	} else {
+		fmt.Printf(" Verify: ok \n")
+	}
+}
+
+func testIR() {
+	fmt.Printf("\n Test 34.1 - IR - phi after if \n")
+	testIRProgram("{varX:=1; varY:=2; if varX<varY {varX = varY+1} else {varY = 3}; print varX+varY}")
+	fmt.Printf("\n Test 34.2 - IR - phis at the head of while, varN is not assigned \n")
+	testIRProgram("{varN:=5; varI:=0; varS:=0; while varI<varN {varS = varS+varI; varI = varI+1}; print varS}")
+	fmt.Printf("\n Test 34.3 - IR - verifier \n")
+	_, _, b := parse("{varX:=1; if true {varX = 2} else {varX = 3}; print varX}")
+	f := buildIR(b)
+	phi := f.blocks[3].instrs[0]
+	phi.from = phi.from[:1]
+	phi.args = phi.args[:1]
+	fmt.Printf("\n Phi with an argument missing: %v ", f.verify())
+	f.blocks[1].instrs = append(f.blocks[1].instrs, f.blocks[0].instrs[0])
+	fmt.Printf("\n Register defined twice: %v \n", f.verify())
+	fmt.Printf("\n Test 34.4 - IR - interpreter against eval \n")
+	testBackend("IR", runIR)
+}
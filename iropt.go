@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Optimizations on the IR
+//
+// A pass rewrites an IRFunc in place and reports whether it changed it. The
+// pass manager runs the selected passes in the order of irPasses until none
+// of them changes the function any more. All passes keep the SSA form. print
+// and assert have side effects (see effect), a pass must neither remove,
+// merge nor move them; every other instruction may be removed, merged or
+// moved.
+
+type irPass struct {
+	name string
+	desc string
+	run  func(f *IRFunc) bool
+}
+
+var irPasses = []irPass{
+	{"copyprop", "copy and constant propagation, constant folding", copyProp},
+	{"cse", "common subexpression elimination", cse},
+	{"licm", "loop-invariant code motion", licm},
+	{"dce", "dead-code elimination", dce},
+}
+
+// Passes selected by a -O flag: a comma separated list of pass names, "all"
+// or "none", a name with a leading - removes the pass again (all,-licm)
+func parsePasses(spec string) (map[string]bool, error) {
+	on := map[string]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		enable := !strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		switch name {
+		case "", "none":
+			continue
+		case "all":
+			for _, p := range irPasses {
+				on[p.name] = enable
+			}
+			continue
+		}
+		known := false
+		for _, p := range irPasses {
+			known = known || p.name == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown pass %q", name)
+		}
+		on[name] = enable
+	}
+	return on, nil
+}
+
+// Runs the passes until nothing changes, returns the passes that changed f
+func optimizeIR(f *IRFunc, on map[string]bool) []string {
+	var log []string
+	for round := 0; round < 10; round++ {
+		changed := false
+		for _, p := range irPasses {
+			if on[p.name] && p.run(f) {
+				log = append(log, p.name)
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return log
+}
+
+// Keeps the instructions of every block for which keep returns true
+func (f *IRFunc) filter(keep func(in *Instr) bool) bool {
+	changed := false
+	for _, bb := range f.blocks {
+		var instrs []*Instr
+		for _, in := range bb.instrs {
+			if keep(in) {
+				instrs = append(instrs, in)
+			} else {
+				changed = true
+			}
+		}
+		bb.instrs = instrs
+	}
+	return changed
+}
+
+// Instructions without side effects that compute a value from their arguments
+func pure(in *Instr) bool {
+	return in.op != "phi" && !effect(in)
+}
+
+// print and assert, they are kept even though they define no register
+func effect(in *Instr) bool {
+	return in.op == "print" || in.op == "assert"
+}
+
+// Copy and constant propagation: uses of a copy are replaced by its argument,
+// instructions with constant arguments by their value
+func copyProp(f *IRFunc) bool {
+	changed := f.filter(func(in *Instr) bool {
+		if in.op == "copy" {
+			f.replace(in.dst, in.args[0])
+			return false
+		}
+		if !pure(in) {
+			return true
+		}
+		var v [2]int
+		for i, a := range in.args {
+			if a.reg != 0 {
+				return true
+			}
+			v[i] = a.val
+		}
+		r, _ := irEval(in.op, v[0], v[1])
+		f.replace(in.dst, constant(r, f.types[in.dst]))
+		return false
+	})
+	n := f.count()
+	f.removeTrivialPhis()
+	return changed || f.count() != n
+}
+
+func (f *IRFunc) count() int {
+	n := 0
+	for _, bb := range f.blocks {
+		n += len(bb.instrs)
+	}
+	return n
+}
+
+// Dominators of every block, dom[b][a] is true if a dominates b
+func (f *IRFunc) dominators() []map[int]bool {
+	preds := f.preds()
+	dom := make([]map[int]bool, len(f.blocks))
+	dom[0] = map[int]bool{0: true}
+	for b := 1; b < len(f.blocks); b++ {
+		dom[b] = map[int]bool{}
+		for a := range f.blocks {
+			dom[b][a] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for b := 1; b < len(f.blocks); b++ {
+			d := map[int]bool{b: true}
+			for a := range f.blocks {
+				all := len(preds[b]) > 0
+				for _, p := range preds[b] {
+					all = all && dom[p][a]
+				}
+				if all {
+					d[a] = true
+				}
+			}
+			if len(d) != len(dom[b]) {
+				dom[b] = d
+				changed = true
+			}
+		}
+	}
+	return dom
+}
+
+// Children of every block in the dominator tree
+func domTree(dom []map[int]bool) [][]int {
+	children := make([][]int, len(dom))
+	for b := 1; b < len(dom); b++ {
+		// The immediate dominator has the most dominators itself
+		idom := -1
+		for a := range dom[b] {
+			if a != b && (idom < 0 || len(dom[a]) > len(dom[idom])) {
+				idom = a
+			}
+		}
+		if idom >= 0 {
+			children[idom] = append(children[idom], b)
+		}
+	}
+	return children
+}
+
+func (f *IRFunc) operandKey(o Operand) string {
+	if o.reg != 0 {
+		return "r" + strconv.Itoa(o.reg)
+	}
+	return strconv.Itoa(o.val) + ":" + typeKeyword(o.ty)
+}
+
+var commutative = map[string]bool{"add": true, "mul": true, "and": true, "or": true, "eq": true, "ne": true}
+
+// Common subexpression elimination: an instruction computing the same as
+// one in a dominating block (or earlier in its block) is replaced by it
+func cse(f *IRFunc) bool {
+	children := domTree(f.dominators())
+	changed := false
+	var walk func(b int, avail map[string]Operand)
+	walk = func(b int, avail map[string]Operand) {
+		seen := map[string]Operand{}
+		for k, v := range avail {
+			seen[k] = v
+		}
+		bb := f.blocks[b]
+		var instrs []*Instr
+		for _, in := range bb.instrs {
+			if !pure(in) {
+				instrs = append(instrs, in)
+				continue
+			}
+			var args []string
+			for _, a := range in.args {
+				args = append(args, f.operandKey(a))
+			}
+			if commutative[in.op] {
+				sort.Strings(args)
+			}
+			key := in.op + " " + strings.Join(args, ",")
+			if v, ok := seen[key]; ok {
+				f.replace(in.dst, v)
+				changed = true
+				continue
+			}
+			seen[key] = f.reg(in.dst)
+			instrs = append(instrs, in)
+		}
+		bb.instrs = instrs
+		for _, c := range children[b] {
+			walk(c, seen)
+		}
+	}
+	walk(0, map[string]Operand{})
+	return changed
+}
+
+// Dead-code elimination: removes instructions whose value is not used by a
+// print, an assert or a branch, also cycles of phis only used by each other
+func dce(f *IRFunc) bool {
+	def := map[int]*Instr{}
+	for _, bb := range f.blocks {
+		for _, in := range bb.instrs {
+			if in.dst != 0 {
+				def[in.dst] = in
+			}
+		}
+	}
+	live := map[int]bool{}
+	var mark func(o Operand)
+	mark = func(o Operand) {
+		if o.reg == 0 || live[o.reg] {
+			return
+		}
+		live[o.reg] = true
+		if in, ok := def[o.reg]; ok {
+			for _, a := range in.args {
+				mark(a)
+			}
+		}
+	}
+	for _, bb := range f.blocks {
+		for _, in := range bb.instrs {
+			if effect(in) {
+				mark(in.args[0])
+			}
+		}
+		if len(bb.succ) == 2 {
+			mark(bb.cond)
+		}
+	}
+	return f.filter(func(in *Instr) bool {
+		return effect(in) || live[in.dst]
+	})
+}
+
+// Loop-invariant code motion: instructions of a loop whose arguments are all
+// defined outside of it are moved to the end of the block before the loop.
+// Instructions cannot trap, so moving them out of a branch is safe.
+func licm(f *IRFunc) bool {
+	dom := f.dominators()
+	preds := f.preds()
+	changed := false
+	for _, tail := range f.blocks {
+		for _, head := range tail.succ {
+			if !dom[tail.id][head] {
+				continue
+			}
+			// Blocks of the natural loop of the back edge tail -> head
+			loop := map[int]bool{head: true}
+			stack := []int{tail.id}
+			for len(stack) > 0 {
+				b := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if loop[b] {
+					continue
+				}
+				loop[b] = true
+				stack = append(stack, preds[b]...)
+			}
+			var outside []int
+			for _, p := range preds[head] {
+				if !loop[p] {
+					outside = append(outside, p)
+				}
+			}
+			if len(outside) != 1 || len(f.blocks[outside[0]].succ) != 1 {
+				continue
+			}
+			pre := f.blocks[outside[0]]
+			inLoop := map[int]bool{}
+			var ids []int
+			for b := range loop {
+				ids = append(ids, b)
+				for _, in := range f.blocks[b].instrs {
+					if in.dst != 0 {
+						inLoop[in.dst] = true
+					}
+				}
+			}
+			sort.Ints(ids)
+			for moved := true; moved; {
+				moved = false
+				for _, b := range ids {
+					bb := f.blocks[b]
+					var instrs []*Instr
+					for _, in := range bb.instrs {
+						invariant := pure(in)
+						for _, a := range in.args {
+							invariant = invariant && !inLoop[a.reg]
+						}
+						if invariant {
+							pre.instrs = append(pre.instrs, in)
+							delete(inLoop, in.dst)
+							moved, changed = true, true
+						} else {
+							instrs = append(instrs, in)
+						}
+					}
+					bb.instrs = instrs
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// Runs a program through the IR interpreter after optimizing it
+func runIROpt(on map[string]bool) func(b Block) (string, error) {
+	return func(b Block) (string, error) {
+		var buf strings.Builder
+		f := buildIR(b)
+		optimizeIR(f, on)
+		if err := f.verify(); err != nil {
+			return "", err
+		}
+		err := f.run(&buf)
+		return buf.String(), err
+	}
+}
+
+var irOptExample = "{varA:=2; varB:=3; if varA<varB {varA = varA+varB} else {varB = varB*2}; " +
+	"varI:=0; varS:=0; while varI<varA*varB {varS = varS+varA*varB; varI = varI+1}; varD:=varS+varS; print varS}"
+
+func testIROptimizer() {
+	fmt.Printf("\n Test 35.1 - IR Optimizer - -O=all \n")
+	_, _, b := parse(irOptExample)
+	f := buildIR(b)
+	on, _ := parsePasses("all")
+	log := optimizeIR(f, on)
+	fmt.Printf("\n Input: %s\n Passes: %s\n\n%s", irOptExample, strings.Join(log, " "), f.pretty())
+	fmt.Printf("\n Test 35.2 - IR Optimizer - one pass at a time, number of instructions \n")
+	for _, p := range irPasses {
+		f := buildIR(b)
+		n := f.count()
+		changed := len(optimizeIR(f, map[string]bool{p.name: true})) > 0
+		fmt.Printf("\n -O=%-9s %2d -> %2d  changed: %-5t  %s", p.name, n, f.count(), changed, p.desc)
+	}
+	fmt.Printf("\n")
+	fmt.Printf("\n Test 35.3 - IR Optimizer - differential test against eval \n")
+	programs := append([]string{irOptExample}, backendCorpus...)
+	for _, spec := range []string{"none", "copyprop", "cse", "licm", "dce", "all", "all,-copyprop"} {
+		on, _ := parsePasses(spec)
+		same := 0
+		for _, src := range programs {
+			_, _, b := parse(src)
+			got, err := runIROpt(on)(b)
+			if want, wantErr := evalOutput(b); failsAlike(err, wantErr) && got == want {
+				same++
+			}
+		}
+		fmt.Printf("\n -O=%-14s %d of %d programs print the same as eval", spec, same, len(programs))
+	}
+	fmt.Printf("\n")
+	fmt.Printf("\n Test 35.4 - IR Optimizer - unknown pass \n")
+	_, err := parsePasses("cse,gvn")
+	fmt.Printf("\n -O=cse,gvn: %v \n", err)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// JavaScript backend
+//
+// Translates a checked Block into an ES2020 module exporting main(print).
+// print is called with a BigInt or a boolean for every print statement and
+// defaults to console.log. Ints are BigInts, every arithmetic result is
+// wrapped with BigInt.asIntN(64, ...) so that overflow behaves like Go int.
+// All variables are declared with let at the start of main. A failing assert
+// throws an Error with its message.
+
+type jsGen struct {
+	buf    strings.Builder
+	indent int
+}
+
+func genJS(b Block) string {
+	g := &jsGen{indent: 1}
+	g.buf.WriteString("// Code generated by imp build -target=js. DO NOT EDIT.\n\n")
+	g.buf.WriteString("export function main(print = (v) => console.log(String(v))) {\n")
+	for _, v := range declaredVars(b) {
+		if v.ty == TyBool {
+			g.line("let %s = false;", typedName(v.name, v.ty))
+		} else {
+			g.line("let %s = 0n;", typedName(v.name, v.ty))
+		}
+	}
+	g.stmt(b.s, make(TyState))
+	g.buf.WriteString("}\n")
+	return g.buf.String()
+}
+
+func (g *jsGen) line(format string, args ...any) {
+	g.buf.WriteString(strings.Repeat("  ", g.indent))
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteString("\n")
+}
+
+func (g *jsGen) block(b Block, t TyState) {
+	g.indent++
+	g.stmt(b.s, t)
+	g.indent--
+}
+
+func (g *jsGen) stmt(s Stmt, t TyState) {
+	switch s := s.(type) {
+	case ComS:
+		g.stmt(s[0], t)
+		g.stmt(s[1], t)
+	case Decl:
+		e := jsExp(s.rhs, t)
+		g.line("%s = %s;", typedName(s.lhs, declare(s, t)), e)
+	case Assign:
+		g.line("%s = %s;", typedName(s.name, t[s.name]), jsExp(s.value, t))
+	case Print:
+		g.line("print(%s);", jsExp(s.e, t))
+	case Assert:
+		g.line("if (!%s) {", jsCond(s.e, t))
+		g.indent++
+		g.line("throw new Error(%s);", strconv.Quote(assertMessage(s)))
+		g.indent--
+		g.line("}")
+	case IfEl:
+		t1, t2 := copyTyState(t), copyTyState(t)
+		g.line("if %s {", jsCond(s.e, t))
+		g.block(s.b1, t1)
+		g.line("} else {")
+		g.block(s.b2, t2)
+		g.line("}")
+		joinTyStates(t, t1, t2, "", "")
+	case While:
+		g.line("while %s {", jsCond(s.e, t))
+		g.block(s.b, copyTyState(t))
+		g.line("}")
+	}
+}
+
+// Condition in parentheses, comparisons and && || already have them
+func jsCond(e Exp, t TyState) string {
+	if isBinary(e) {
+		return jsExp(e, t)
+	}
+	return "(" + jsExp(e, t) + ")"
+}
+
+// Operators of the binary expressions, arithmetic ones are wrapped
+var jsOps = map[string]string{
+	"Plus": "+", "Minus": "-", "Mult": "*", "And": "&&", "Or": "||",
+	"Equ": "===", "Neq": "!==", "Les": "<", "Leq": "<=", "Gre": ">", "Geq": ">=",
+}
+
+func jsExp(e Exp, t TyState) string {
+	switch e := e.(type) {
+	case Num:
+		return fmt.Sprintf("%dn", int(e))
+	case Bool:
+		return e.pretty()
+	case Var:
+		return typedName(string(e), t[string(e)])
+	case Neg:
+		return "!" + jsExp(e[0], t)
+	}
+	c := expChildren(e)
+	k := kindOf(e)
+	x := jsExp(c[0], t) + " " + jsOps[k] + " " + jsExp(c[1], t)
+	switch k {
+	case "Plus", "Minus", "Mult":
+		return "BigInt.asIntN(64, " + x + ")"
+	}
+	return "(" + x + ")"
+}
+
+func runJS(b Block) (string, error) {
+	run := "import { main } from \"./prog.mjs\";\nmain();\n"
+	return runInTempDir(map[string]string{"prog.mjs": genJS(b), "run.mjs": run},
+		[]string{"node", "run.mjs"})
+}
+
+// Snapshots of the generated code, one per program of the corpus
+func jsSnapshot(i int) string {
+	return fmt.Sprintf("testdata/js/prog%d.js", i+1)
+}
+
+func testJSBackend() {
+	fmt.Printf("\n Test 33.1 - JavaScript Backend - generated code \n")
+	src := "{varX:=1;while varX<3 {print varX*2 == 2; varX = varX+1}}"
+	_, _, b := parse(src)
+	fmt.Printf("\n Input: %s\n\n%s", src, genJS(b))
+	fmt.Printf("\n Test 33.2 - JavaScript Backend - snapshots \n")
+	for i, src := range backendCorpus {
+		_, _, b := parse(src)
+		want, err := os.ReadFile(jsSnapshot(i))
+		if err != nil {
+			fmt.Printf("\n ERROR ON SNAPSHOT \n %s \n", err)
+			continue
+		}
+		fmt.Printf("\n Input: %s\n Same as %s: %t ", src, jsSnapshot(i), genJS(b) == string(want))
+	}
+	fmt.Printf("\n")
+	fmt.Printf("\n Test 33.3 - JavaScript Backend - node against the interpreter \n")
+	if !haveTools("node") {
+		fmt.Printf("\n node not found, skipped \n")
+		return
+	}
+	testBackend("JS BACKEND", runJS)
+	fmt.Printf("\n Test 33.4 - JavaScript Backend - print callback \n")
+	src = "{varX:=1; print varX+1; print varX<1}"
+	_, _, b = parse(src)
+	run := "import { main } from \"./prog.mjs\";\n" +
+		"main((v) => console.log(typeof v, String(v)));\n"
+	out, err := runInTempDir(map[string]string{"prog.mjs": genJS(b), "run.mjs": run},
+		[]string{"node", "run.mjs"})
+	if err != nil {
+		fmt.Printf("\n ERROR ON JS BACKEND \n %s \n", err)
+		return
+	}
+	fmt.Printf("\n Input: %s\n Values: %s\n", src, strings.Join(strings.Split(strings.TrimSpace(out), "\n"), ", "))
+}
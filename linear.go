@@ -0,0 +1,450 @@
+package main
+
+import (
+	"math/big"
+	"sort"
+)
+
+// Solver for linear integer constraints
+//
+// A formula over int and bool variables is brought into disjunctive normal
+// form. Each conjunction fixes some bools and holds constraints
+// a1*x1 + ... + an*xn + c <= 0 over the ints. Fourier-Motzkin elimination
+// decides them over the rationals, every derived constraint is tightened to
+// the integers by dividing it by the gcd of its coefficients. Integer values
+// are then searched for by assigning the variables in reverse order of
+// elimination within the bounds the assigned ones leave. Products of
+// variables and systems that grow too large give up with unknown. Integers
+// are unbounded, overflows are not considered.
+
+type satResult int
+
+const (
+	satUnknown satResult = iota
+	satYes
+	satNo
+)
+
+func (r satResult) pretty() string {
+	switch r {
+	case satYes:
+		return "sat"
+	case satNo:
+		return "unsat"
+	}
+	return "unknown"
+}
+
+// Limits of the solver
+const (
+	maxConjunctions = 64
+	maxConstraints  = 400
+	maxSearchSteps  = 10000
+)
+
+// Linear term sum coef[x]*x + c, as a constraint term <= 0
+type linTerm struct {
+	coef map[string]*big.Int
+	c    *big.Int
+}
+
+func constTerm(c int64) linTerm {
+	return linTerm{map[string]*big.Int{}, big.NewInt(c)}
+}
+
+// t + k*u
+func (t linTerm) plus(u linTerm, k *big.Int) linTerm {
+	r := linTerm{map[string]*big.Int{}, new(big.Int).Set(t.c)}
+	for x, a := range t.coef {
+		r.coef[x] = new(big.Int).Set(a)
+	}
+	for x, a := range u.coef {
+		v, ok := r.coef[x]
+		if !ok {
+			v = new(big.Int)
+		}
+		v.Add(v, new(big.Int).Mul(k, a))
+		if v.Sign() == 0 {
+			delete(r.coef, x)
+		} else {
+			r.coef[x] = v
+		}
+	}
+	r.c.Add(r.c, new(big.Int).Mul(k, u.c))
+	return r
+}
+
+func (t linTerm) scale(k *big.Int) linTerm {
+	return constTerm(0).plus(t, k)
+}
+
+var bigOne = big.NewInt(1)
+var bigMinusOne = big.NewInt(-1)
+
+// Integer expression as a linear term, false if it multiplies variables
+func linearize(e Exp) (linTerm, bool) {
+	switch e := e.(type) {
+	case Num:
+		return constTerm(int64(e)), true
+	case Var:
+		t := constTerm(0)
+		t.coef[string(e)] = big.NewInt(1)
+		return t, true
+	case Plus, Minus, Mult:
+		c := expChildren(e)
+		a, ok1 := linearize(c[0])
+		b, ok2 := linearize(c[1])
+		if !ok1 || !ok2 {
+			return linTerm{}, false
+		}
+		switch e.(type) {
+		case Plus:
+			return a.plus(b, bigOne), true
+		case Minus:
+			return a.plus(b, bigMinusOne), true
+		}
+		switch {
+		case len(a.coef) == 0:
+			return b.scale(a.c), true
+		case len(b.coef) == 0:
+			return a.scale(b.c), true
+		}
+	}
+	return linTerm{}, false
+}
+
+// x < y (strict) or x <= y as a constraint
+func lessThan(x, y Exp, strict bool) (linTerm, bool) {
+	a, ok1 := linearize(x)
+	b, ok2 := linearize(y)
+	if !ok1 || !ok2 {
+		return linTerm{}, false
+	}
+	t := a.plus(b, bigMinusOne)
+	if strict {
+		t.c.Add(t.c, bigOne)
+	}
+	return t, true
+}
+
+// Conjunction of bool literals and linear constraints
+type linConj struct {
+	cons  []linTerm
+	bools map[string]bool
+}
+
+func literal(cons []linTerm, bools map[string]bool) []linConj {
+	if bools == nil {
+		bools = map[string]bool{}
+	}
+	return []linConj{{cons, bools}}
+}
+
+// Conjunctions of every pair, pairs with contradicting bools are dropped
+func product(as, bs []linConj) ([]linConj, bool) {
+	var r []linConj
+	for _, a := range as {
+	pairs:
+		for _, b := range bs {
+			bools := map[string]bool{}
+			for x, v := range a.bools {
+				bools[x] = v
+			}
+			for x, v := range b.bools {
+				if w, ok := bools[x]; ok && w != v {
+					continue pairs
+				}
+				bools[x] = v
+			}
+			cons := append(append([]linTerm{}, a.cons...), b.cons...)
+			r = append(r, linConj{cons, bools})
+		}
+	}
+	return r, len(r) <= maxConjunctions
+}
+
+// Disjunctive normal form of e if want is true, of !e otherwise. t holds the
+// types of the variables. False if e is not linear or the form too large.
+func dnf(e Exp, want bool, t TyState) ([]linConj, bool) {
+	switch e := e.(type) {
+	case Bool:
+		if bool(e) == want {
+			return literal(nil, nil), true
+		}
+		return nil, true
+	case Var:
+		return literal(nil, map[string]bool{string(e): want}), true
+	case Neg:
+		return dnf(e[0], !want, t)
+	case And, Or:
+		c := expChildren(e)
+		a, ok1 := dnf(c[0], want, t)
+		b, ok2 := dnf(c[1], want, t)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		// !(a && b) is !a || !b, !(a || b) is !a && !b
+		if _, and := e.(And); and == want {
+			return product(a, b)
+		}
+		r := append(a, b...)
+		return r, len(r) <= maxConjunctions
+	case Equ, Neq:
+		c := expChildren(e)
+		_, equ := e.(Equ)
+		eq := equ == want
+		if ty, _ := c[0].infer(t); ty == TyBool {
+			// a == b is a && b || !a && !b, a != b is a && !b || !a && b
+			var r []linConj
+			for _, v := range []bool{true, false} {
+				a, ok1 := dnf(c[0], v, t)
+				b, ok2 := dnf(c[1], v == eq, t)
+				if !ok1 || !ok2 {
+					return nil, false
+				}
+				p, ok := product(a, b)
+				if !ok {
+					return nil, false
+				}
+				r = append(r, p...)
+			}
+			return r, len(r) <= maxConjunctions
+		}
+		le, ok1 := lessThan(c[0], c[1], false)
+		ge, ok2 := lessThan(c[1], c[0], false)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		if eq {
+			return literal([]linTerm{le, ge}, nil), true
+		}
+		lt, _ := lessThan(c[0], c[1], true)
+		gt, _ := lessThan(c[1], c[0], true)
+		return append(literal([]linTerm{lt}, nil), literal([]linTerm{gt}, nil)...), true
+	case Les, Leq, Gre, Geq:
+		c := expChildren(e)
+		x, y := c[0], c[1]
+		var strict bool
+		switch e.(type) {
+		case Les:
+			strict = true
+		case Leq:
+		case Gre:
+			x, y, strict = y, x, true
+		case Geq:
+			x, y = y, x
+		}
+		// !(x < y) is y <= x, !(x <= y) is y < x
+		if !want {
+			x, y, strict = y, x, !strict
+		}
+		l, ok := lessThan(x, y, strict)
+		if !ok {
+			return nil, false
+		}
+		return literal([]linTerm{l}, nil), true
+	}
+	return nil, false
+}
+
+// Values of the variables of f making it true, t holds their types
+func solve(f Exp, t TyState) (ValState, satResult) {
+	cs, ok := dnf(f, true, t)
+	if !ok {
+		return nil, satUnknown
+	}
+	result := satNo
+	for _, c := range cs {
+		ints, r := solveLinear(c.cons)
+		if r == satYes {
+			model := ValState{}
+			for x, v := range c.bools {
+				model[x] = mkBool(v)
+			}
+			for x, v := range ints {
+				model[x] = mkInt(v)
+			}
+			return model, satYes
+		}
+		if r == satUnknown {
+			result = satUnknown
+		}
+	}
+	return nil, result
+}
+
+// Divides the coefficients by their gcd and rounds the constant up, which
+// keeps every integer solution
+func tighten(t linTerm) linTerm {
+	g := new(big.Int)
+	for _, a := range t.coef {
+		g.GCD(nil, nil, g, new(big.Int).Abs(a))
+	}
+	if g.Sign() == 0 || g.Cmp(bigOne) == 0 {
+		return t
+	}
+	r := linTerm{map[string]*big.Int{}, new(big.Int)}
+	for x, a := range t.coef {
+		r.coef[x] = new(big.Int).Quo(a, g)
+	}
+	// ceil(c/g) = -floor(-c/g)
+	r.c.Neg(new(big.Int).Div(new(big.Int).Neg(t.c), g))
+	return r
+}
+
+// Solves the constraints over the integers
+func solveLinear(cons []linTerm) (map[string]int, satResult) {
+	seen := map[string]bool{}
+	var vars []string
+	for _, c := range cons {
+		for x := range c.coef {
+			if !seen[x] {
+				seen[x] = true
+				vars = append(vars, x)
+			}
+		}
+	}
+	sort.Strings(vars)
+	// levels[i] is the system before vars[i] is eliminated
+	levels := [][]linTerm{}
+	sys := make([]linTerm, 0, len(cons))
+	for _, c := range cons {
+		sys = append(sys, tighten(c))
+	}
+	for _, x := range vars {
+		levels = append(levels, sys)
+		var next, pos, neg []linTerm
+		for _, c := range sys {
+			a, ok := c.coef[x]
+			switch {
+			case !ok:
+				next = append(next, c)
+			case a.Sign() > 0:
+				pos = append(pos, c)
+			default:
+				neg = append(neg, c)
+			}
+		}
+		for _, p := range pos {
+			for _, n := range neg {
+				// (-a_n)*p + a_p*n has no x
+				k := new(big.Int).Neg(n.coef[x])
+				next = append(next, tighten(p.scale(k).plus(n, p.coef[x])))
+			}
+		}
+		if len(next) > maxConstraints {
+			return nil, satUnknown
+		}
+		sys = next
+	}
+	for _, c := range sys {
+		if c.c.Sign() > 0 {
+			return nil, satNo
+		}
+	}
+	s := &linSearch{vars: vars, levels: levels, model: map[string]*big.Int{}}
+	if !s.assign(len(vars) - 1) {
+		return nil, satUnknown
+	}
+	ints := map[string]int{}
+	for x, v := range s.model {
+		if !v.IsInt64() {
+			return nil, satUnknown
+		}
+		ints[x] = int(v.Int64())
+	}
+	return ints, satYes
+}
+
+type linSearch struct {
+	vars   []string
+	levels [][]linTerm
+	model  map[string]*big.Int
+	steps  int
+}
+
+// Assigns vars[i] and the variables before it, the ones after it are set
+func (s *linSearch) assign(i int) bool {
+	if i < 0 {
+		return true
+	}
+	s.steps++
+	if s.steps > maxSearchSteps {
+		return false
+	}
+	x := s.vars[i]
+	var lo, hi *big.Int
+	for _, c := range s.levels[i] {
+		a, ok := c.coef[x]
+		if !ok {
+			continue
+		}
+		// a*x <= rest
+		rest := new(big.Int).Set(c.c)
+		for y, b := range c.coef {
+			if y != x {
+				rest.Add(rest, new(big.Int).Mul(b, s.model[y]))
+			}
+		}
+		rest.Neg(rest)
+		if a.Sign() > 0 {
+			// x <= floor(rest/a)
+			v := new(big.Int).Div(rest, a)
+			if hi == nil || v.Cmp(hi) < 0 {
+				hi = v
+			}
+		} else {
+			// x >= ceil(rest/a) = -floor(rest/-a)
+			v := new(big.Int).Neg(new(big.Int).Div(rest, new(big.Int).Neg(a)))
+			if lo == nil || v.Cmp(lo) > 0 {
+				lo = v
+			}
+		}
+	}
+	for _, v := range candidates(lo, hi) {
+		s.model[x] = v
+		if s.assign(i - 1) {
+			return true
+		}
+	}
+	delete(s.model, x)
+	return false
+}
+
+// Values between lo and hi (nil if unbounded) to try, the one closest to 0
+// first, then the bounds and their neighbours
+func candidates(lo, hi *big.Int) []*big.Int {
+	if lo != nil && hi != nil && lo.Cmp(hi) > 0 {
+		return nil
+	}
+	in := func(v *big.Int) bool {
+		return (lo == nil || v.Cmp(lo) >= 0) && (hi == nil || v.Cmp(hi) <= 0)
+	}
+	zero := new(big.Int)
+	switch {
+	case lo != nil && lo.Sign() > 0:
+		zero.Set(lo)
+	case hi != nil && hi.Sign() < 0:
+		zero.Set(hi)
+	}
+	var vs []*big.Int
+	seen := map[string]bool{}
+	for _, v := range []*big.Int{zero, lo, hi, new(big.Int).Add(zero, bigOne), new(big.Int).Sub(zero, bigOne)} {
+		if v != nil && in(v) && !seen[v.String()] {
+			seen[v.String()] = true
+			vs = append(vs, v)
+		}
+	}
+	if lo != nil {
+		if v := new(big.Int).Add(lo, bigOne); in(v) && !seen[v.String()] {
+			seen[v.String()] = true
+			vs = append(vs, v)
+		}
+	}
+	if hi != nil {
+		if v := new(big.Int).Sub(hi, bigOne); in(v) && !seen[v.String()] {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
@@ -0,0 +1,247 @@
+package main
+
+import "fmt"
+
+// Lint pass
+//
+// Warns about variables that are declared but never read, values that are
+// never read before being overwritten, code after a loop that never ends or
+// an assertion that always fails and conditions that are constant. Conditions
+// are folded with optExp first.
+
+func lint(b Block) []Diagnostic {
+	var ds []Diagnostic
+	unused := map[string]bool{}
+	for _, x := range unusedVars(b) {
+		unused[x] = true
+		ds = append(ds, warning("variable "+x+" is declared but never read"))
+	}
+	// The liveness analysis runs backwards, stores are reported in reverse
+	var dead []Diagnostic
+	liveStmt(b.s, liveSet{}, func(x string, s Stmt) {
+		if !unused[x] {
+			dead = append(dead, warning("value assigned to "+x+" is never read: "+s.pretty()))
+		}
+	})
+	for i := len(dead) - 1; i >= 0; i-- {
+		ds = append(ds, dead[i])
+	}
+	lintReach(b.s, &ds)
+	return ds
+}
+
+// Unused variables
+
+// Declared variables that are never read, in order of declaration
+func unusedVars(b Block) []string {
+	var decls []string
+	read := map[string]bool{}
+	var walk func(s Stmt)
+	walk = func(s Stmt) {
+		switch s := s.(type) {
+		case ComS:
+			walk(s[0])
+			walk(s[1])
+		case Decl:
+			decls = append(decls, s.lhs)
+			markRead(read, s.rhs)
+		case Assign:
+			markRead(read, s.value)
+		case Print:
+			markRead(read, s.e)
+		case Assert:
+			markRead(read, s.e)
+		case Assume:
+			markRead(read, s.e)
+		case IfEl:
+			markRead(read, s.e)
+			walk(s.b1.s)
+			walk(s.b2.s)
+		case While:
+			markRead(read, s.e)
+			if s.inv != nil {
+				markRead(read, s.inv)
+			}
+			walk(s.b.s)
+		}
+	}
+	walk(b.s)
+	var names []string
+	seen := map[string]bool{}
+	for _, x := range decls {
+		if !read[x] && !seen[x] {
+			seen[x] = true
+			names = append(names, x)
+		}
+	}
+	return names
+}
+
+func markRead(read map[string]bool, e Exp) {
+	for _, x := range expVars(e) {
+		read[x] = true
+	}
+}
+
+// Dead stores, found by a backward liveness analysis
+
+type liveSet map[string]bool
+
+func (l liveSet) copy() liveSet {
+	l2 := liveSet{}
+	for x := range l {
+		l2[x] = true
+	}
+	return l2
+}
+
+func (l liveSet) equal(l2 liveSet) bool {
+	if len(l) != len(l2) {
+		return false
+	}
+	for x := range l {
+		if !l2[x] {
+			return false
+		}
+	}
+	return true
+}
+
+// Variables live before s given the variables live after it. Stores to a
+// variable that is not live afterwards are passed to dead, a nil dead only
+// computes the set.
+func liveStmt(s Stmt, out liveSet, dead func(x string, s Stmt)) liveSet {
+	switch s := s.(type) {
+	case ComS:
+		return liveStmt(s[0], liveStmt(s[1], out, dead), dead)
+	case Decl:
+		// The zero value of var x T is not a store the program asked for
+		if s.zero {
+			return liveStore(s.lhs, s.rhs, s, out, nil)
+		}
+		return liveStore(s.lhs, s.rhs, s, out, dead)
+	case Assign:
+		return liveStore(s.name, s.value, s, out, dead)
+	case Print:
+		in := out.copy()
+		markRead(in, s.e)
+		return in
+	case Assert:
+		in := out.copy()
+		markRead(in, s.e)
+		return in
+	case Assume:
+		in := out.copy()
+		markRead(in, s.e)
+		return in
+	case IfEl:
+		in := liveStmt(s.b1.s, out, dead)
+		for x := range liveStmt(s.b2.s, out, dead) {
+			in[x] = true
+		}
+		markRead(in, s.e)
+		return in
+	case While:
+		// Fixed point of in = out + vars(e) + live(body, in), the
+		// invariant is read with the condition
+		in := out.copy()
+		markRead(in, s.e)
+		if s.inv != nil {
+			markRead(in, s.inv)
+		}
+		for {
+			next := in.copy()
+			for x := range liveStmt(s.b.s, in, nil) {
+				next[x] = true
+			}
+			if next.equal(in) {
+				break
+			}
+			in = next
+		}
+		liveStmt(s.b.s, in, dead)
+		return in
+	}
+	return out.copy()
+}
+
+func liveStore(x string, e Exp, s Stmt, out liveSet, dead func(x string, s Stmt)) liveSet {
+	if !out[x] && dead != nil {
+		dead(x, s)
+	}
+	in := out.copy()
+	delete(in, x)
+	markRead(in, e)
+	return in
+}
+
+// Unreachable code and constant conditions
+
+// True if executing s never reaches the statement after it
+func diverges(s Stmt) bool {
+	switch s := s.(type) {
+	case ComS:
+		return diverges(s[0]) || diverges(s[1])
+	case IfEl:
+		return diverges(s.b1.s) && diverges(s.b2.s)
+	case While:
+		return isBool(optExp(s.e), true)
+	case Assert:
+		return isBool(optExp(s.e), false)
+	}
+	return false
+}
+
+func lintReach(s Stmt, ds *[]Diagnostic) {
+	switch s := s.(type) {
+	case ComS:
+		lintReach(s[0], ds)
+		if diverges(s[0]) {
+			*ds = append(*ds, warning("unreachable code: "+s[1].pretty()))
+			return
+		}
+		lintReach(s[1], ds)
+	case IfEl:
+		lintCondition("if", s.e, ds)
+		lintReach(s.b1.s, ds)
+		lintReach(s.b2.s, ds)
+	case While:
+		lintCondition("while", s.e, ds)
+		lintReach(s.b.s, ds)
+	}
+}
+
+func lintCondition(stmt string, e Exp, ds *[]Diagnostic) {
+	if c, ok := optExp(e).(Bool); ok {
+		*ds = append(*ds, warning("condition of "+stmt+" "+e.pretty()+" is always "+c.pretty()))
+	}
+}
+
+func testLintProgram(s string) {
+	stmt, errorAt, e := parse(s)
+	fmt.Printf("\n Input: %s", s)
+	if !stmt {
+		fmt.Printf("\n ERROR ON PARSE \n AT CHARACTER %d \n", errorAt)
+		return
+	}
+	fmt.Printf("\n Output Parse: %s", e.pretty())
+	ds := lint(e)
+	fmt.Printf("\n Warnings: %d", len(ds))
+	for _, d := range ds {
+		fmt.Printf("\n %s", d.pretty())
+	}
+	fmt.Printf("\n")
+}
+
+func testLint() {
+	fmt.Printf("\n Test 24.1 - Lint - unused variable \n")
+	testLintProgram("{varX:=1; varY:=2; print varX}")
+	fmt.Printf("\n Test 24.2 - Lint - value overwritten before being read \n")
+	testLintProgram("{varX:=1; varX = 2; print varX; varX = 3}")
+	testLintProgram("{varX:=0; while varX<3 {varX = varX+1}; print varX}")
+	testLintProgram("{var varX int; varX = 3; print varX}")
+	fmt.Printf("\n Test 24.3 - Lint - unreachable code after while true \n")
+	testLintProgram("{varX:=0; while true {varX = varX+1; print varX}; print varX}")
+	fmt.Printf("\n Test 24.4 - Lint - constant condition \n")
+	testLintProgram("{varX:=0; if 1 < 2 {print varX} else {print 0}}")
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LLVM IR backend
+//
+// Translates a checked Block into a textual LLVM module with a main function.
+// Every variable gets an alloca in the entry block and is loaded and stored,
+// mem2reg of opt turns them into registers. Ints are i64, bools i1, IfEl and
+// While become br between labelled blocks, print calls printf or puts. A
+// failing assert writes its message to stderr with dprintf and calls exit(1).
+// The IR uses typed pointers, which LLVM 14 needs and later versions still
+// parse.
+
+type llvmGen struct {
+	buf     strings.Builder
+	tmp     int
+	labels  int
+	asserts []string // messages, @.assert<n> is the n-th
+}
+
+func genLLVM(b Block) string {
+	g := &llvmGen{}
+	g.buf.WriteString("@.fmt_int = private unnamed_addr constant [5 x i8] c\"%ld\\0A\\00\"\n")
+	g.buf.WriteString("@.true = private unnamed_addr constant [5 x i8] c\"true\\00\"\n")
+	g.buf.WriteString("@.false = private unnamed_addr constant [6 x i8] c\"false\\00\"\n\n")
+	g.buf.WriteString("declare i32 @printf(i8*, ...)\n")
+	g.buf.WriteString("declare i32 @puts(i8*)\n\n")
+	g.buf.WriteString("define i32 @main() {\nentry:\n")
+	for _, v := range declaredVars(b) {
+		g.line("%%%s = alloca %s", typedName(v.name, v.ty), llvmType(v.ty))
+	}
+	g.stmt(b.s, make(TyState))
+	g.line("ret i32 0")
+	g.buf.WriteString("}\n")
+	if len(g.asserts) > 0 {
+		g.buf.WriteString("\n")
+		for i, msg := range g.asserts {
+			fmt.Fprintf(&g.buf, "@.assert%d = private unnamed_addr constant [%d x i8] c\"%s\\0A\\00\"\n", i+1, len(msg)+2, llvmString(msg))
+		}
+		g.buf.WriteString("\ndeclare i32 @dprintf(i32, i8*, ...)\n")
+		g.buf.WriteString("declare void @exit(i32)\n")
+	}
+	return g.buf.String()
+}
+
+// Contents of a c"..." constant, " and \ and other bytes as \XX
+func llvmString(s string) string {
+	var x strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == '"' || c == '\\' {
+			fmt.Fprintf(&x, "\\%02X", c)
+		} else {
+			x.WriteByte(c)
+		}
+	}
+	return x.String()
+}
+
+func llvmType(ty Type) string {
+	if ty == TyBool {
+		return "i1"
+	}
+	return "i64"
+}
+
+func (g *llvmGen) line(format string, args ...any) {
+	g.buf.WriteString("  ")
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteString("\n")
+}
+
+func (g *llvmGen) block(name string) {
+	g.buf.WriteString(name + ":\n")
+}
+
+// Fresh SSA value
+func (g *llvmGen) value() string {
+	g.tmp++
+	return fmt.Sprintf("%%t%d", g.tmp)
+}
+
+func (g *llvmGen) stmt(s Stmt, t TyState) {
+	switch s := s.(type) {
+	case ComS:
+		g.stmt(s[0], t)
+		g.stmt(s[1], t)
+	case Decl:
+		v := g.exp(s.rhs, t)
+		ty := declare(s, t)
+		g.line("store %s %s, %s* %%%s", llvmType(ty), v, llvmType(ty), typedName(s.lhs, ty))
+	case Assign:
+		v := g.exp(s.value, t)
+		ty := t[s.name]
+		g.line("store %s %s, %s* %%%s", llvmType(ty), v, llvmType(ty), typedName(s.name, ty))
+	case Print:
+		v := g.exp(s.e, t)
+		if ty, _ := s.e.infer(t); ty == TyBool {
+			tr := "getelementptr inbounds ([5 x i8], [5 x i8]* @.true, i64 0, i64 0)"
+			fa := "getelementptr inbounds ([6 x i8], [6 x i8]* @.false, i64 0, i64 0)"
+			p := g.value()
+			g.line("%s = select i1 %s, i8* %s, i8* %s", p, v, tr, fa)
+			g.line("call i32 @puts(i8* %s)", p)
+		} else {
+			f := "getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0)"
+			g.line("call i32 (i8*, ...) @printf(i8* %s, i64 %s)", f, v)
+		}
+	case Assert:
+		g.labels++
+		n := g.labels
+		msg := assertMessage(s)
+		g.asserts = append(g.asserts, msg)
+		c := g.exp(s.e, t)
+		g.line("br i1 %s, label %%ok%d, label %%fail%d", c, n, n)
+		g.block(fmt.Sprintf("fail%d", n))
+		p := fmt.Sprintf("getelementptr inbounds ([%d x i8], [%d x i8]* @.assert%d, i64 0, i64 0)", len(msg)+2, len(msg)+2, len(g.asserts))
+		g.line("call i32 (i32, i8*, ...) @dprintf(i32 2, i8* %s)", p)
+		g.line("call void @exit(i32 1)")
+		g.line("unreachable")
+		g.block(fmt.Sprintf("ok%d", n))
+	case IfEl:
+		g.labels++
+		n := g.labels
+		t1, t2 := copyTyState(t), copyTyState(t)
+		c := g.exp(s.e, t)
+		g.line("br i1 %s, label %%then%d, label %%else%d", c, n, n)
+		g.block(fmt.Sprintf("then%d", n))
+		g.stmt(s.b1.s, t1)
+		g.line("br label %%end%d", n)
+		g.block(fmt.Sprintf("else%d", n))
+		g.stmt(s.b2.s, t2)
+		g.line("br label %%end%d", n)
+		g.block(fmt.Sprintf("end%d", n))
+		joinTyStates(t, t1, t2, "", "")
+	case While:
+		g.labels++
+		n := g.labels
+		g.line("br label %%cond%d", n)
+		g.block(fmt.Sprintf("cond%d", n))
+		c := g.exp(s.e, t)
+		g.line("br i1 %s, label %%body%d, label %%done%d", c, n, n)
+		g.block(fmt.Sprintf("body%d", n))
+		g.stmt(s.b.s, copyTyState(t))
+		g.line("br label %%cond%d", n)
+		g.block(fmt.Sprintf("done%d", n))
+	}
+}
+
+// Instructions and icmp predicates of the binary expressions
+var llvmOps = map[string]string{
+	"Plus": "add", "Minus": "sub", "Mult": "mul", "And": "and", "Or": "or",
+	"Equ": "icmp eq", "Neq": "icmp ne", "Les": "icmp slt", "Leq": "icmp sle",
+	"Gre": "icmp sgt", "Geq": "icmp sge",
+}
+
+// Emits the instructions computing e and returns the operand holding it
+func (g *llvmGen) exp(e Exp, t TyState) string {
+	switch e := e.(type) {
+	case Num:
+		return fmt.Sprintf("%d", int(e))
+	case Bool:
+		return e.pretty()
+	case Var:
+		x := string(e)
+		v := g.value()
+		g.line("%s = load %s, %s* %%%s", v, llvmType(t[x]), llvmType(t[x]), typedName(x, t[x]))
+		return v
+	case Neg:
+		a := g.exp(e[0], t)
+		v := g.value()
+		g.line("%s = xor i1 %s, true", v, a)
+		return v
+	}
+	c := expChildren(e)
+	ty, _ := c[0].infer(t)
+	a := g.exp(c[0], t)
+	b := g.exp(c[1], t)
+	v := g.value()
+	g.line("%s = %s %s %s, %s", v, llvmOps[kindOf(e)], llvmType(ty), a, b)
+	return v
+}
+
+func runLLVM(b Block) (string, error) {
+	return runInTempDir(map[string]string{"prog.ll": genLLVM(b)},
+		[]string{"llvm-as", "prog.ll", "-o", "prog.bc"},
+		[]string{"llc", "-relocation-model=pic", "prog.bc", "-o", "prog.s"},
+		[]string{"cc", "-o", "prog", "prog.s"},
+		[]string{"./prog"})
+}
+
+// Golden files of the generated IR, one per program of the corpus
+func llvmGolden(i int) string {
+	return fmt.Sprintf("testdata/llvm/prog%d.ll", i+1)
+}
+
+func testLLVMBackend() {
+	fmt.Printf("\n Test 32.1 - LLVM Backend - generated code \n")
+	src := "{varX:=1;while varX<3 {if varX==2 {print true} else {print varX}; varX = varX+1}}"
+	_, _, b := parse(src)
+	fmt.Printf("\n Input: %s\n\n%s", src, genLLVM(b))
+	fmt.Printf("\n Test 32.2 - LLVM Backend - golden files \n")
+	for i, src := range backendCorpus {
+		_, _, b := parse(src)
+		want, err := os.ReadFile(llvmGolden(i))
+		if err != nil {
+			fmt.Printf("\n ERROR ON GOLDEN FILE \n %s \n", err)
+			continue
+		}
+		fmt.Printf("\n Input: %s\n Same as %s: %t ", src, llvmGolden(i), genLLVM(b) == string(want))
+	}
+	fmt.Printf("\n")
+	fmt.Printf("\n Test 32.3 - LLVM Backend - llvm-as, llc and cc against the interpreter \n")
+	if !haveTools("llvm-as", "llc", "cc") {
+		fmt.Printf("\n llvm-as, llc or cc not found, skipped \n")
+		return
+	}
+	testBackend("LLVM BACKEND", runLLVM)
+}
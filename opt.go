@@ -0,0 +1,231 @@
+package main
+
+import "fmt"
+
+// Constant folding and algebraic simplification
+//
+// The pass expects a type checked program, expressions are free of side
+// effects so operands may be dropped (x*0 => 0, b && false => false).
+
+func optimize(b Block) Block {
+	return Block{optStmt(b.s), b.span}
+}
+
+func optStmt(s Stmt) Stmt {
+	switch s := s.(type) {
+	case ComS:
+		s1 := optStmt(s[0])
+		s2 := optStmt(s[1])
+		if _, ok := s1.(Skip); ok {
+			return s2
+		}
+		if _, ok := s2.(Skip); ok {
+			return s1
+		}
+		return ComS{s1, s2}
+	case Decl:
+		return Decl{s.lhs, optExp(s.rhs), s.ty, s.zero, s.span}
+	case Assign:
+		return Assign{s.name, optExp(s.value), s.span}
+	case Print:
+		return Print{optExp(s.e), s.span}
+	case IfEl:
+		e := optExp(s.e)
+		// The taken branch replaces the if unless its declarations would
+		// move into the enclosing scope
+		if c, ok := e.(Bool); ok {
+			b := s.b2
+			if c {
+				b = s.b1
+			}
+			if !declares(b.s) {
+				return optStmt(b.s)
+			}
+		}
+		return IfEl{e, optimize(s.b1), optimize(s.b2), s.span}
+	case While:
+		e := optExp(s.e)
+		if isBool(e, false) {
+			return Skip{}
+		}
+		var inv Exp
+		if s.inv != nil {
+			inv = optExp(s.inv)
+		}
+		return While{e, optimize(s.b), inv, s.span}
+	case Assert:
+		return Assert{optExp(s.e), s.span}
+	case Assume:
+		return Assume{optExp(s.e), s.span}
+	}
+	return s
+}
+
+// True if s declares a variable outside of nested blocks
+func declares(s Stmt) bool {
+	switch s := s.(type) {
+	case ComS:
+		return declares(s[0]) || declares(s[1])
+	case Decl:
+		return true
+	}
+	return false
+}
+
+func isNum(e Exp, n Num) bool {
+	x, ok := e.(Num)
+	return ok && x == n
+}
+
+func isBool(e Exp, b Bool) bool {
+	x, ok := e.(Bool)
+	return ok && x == b
+}
+
+func optExp(e Exp) Exp {
+	switch e := e.(type) {
+	case Plus:
+		x, y := optExp(e[0]), optExp(e[1])
+		a, ok1 := x.(Num)
+		b, ok2 := y.(Num)
+		switch {
+		case ok1 && ok2:
+			return a + b
+		case isNum(x, 0):
+			return y
+		case isNum(y, 0):
+			return x
+		}
+		return Plus{x, y}
+	case Minus:
+		x, y := optExp(e[0]), optExp(e[1])
+		a, ok1 := x.(Num)
+		b, ok2 := y.(Num)
+		switch {
+		case ok1 && ok2:
+			return a - b
+		case isNum(y, 0):
+			return x
+		}
+		return Minus{x, y}
+	case Mult:
+		x, y := optExp(e[0]), optExp(e[1])
+		a, ok1 := x.(Num)
+		b, ok2 := y.(Num)
+		switch {
+		case ok1 && ok2:
+			return a * b
+		case isNum(x, 0) || isNum(y, 0):
+			return Num(0)
+		case isNum(x, 1):
+			return y
+		case isNum(y, 1):
+			return x
+		}
+		return Mult{x, y}
+	case And:
+		x, y := optExp(e[0]), optExp(e[1])
+		switch {
+		case isBool(x, false) || isBool(y, false):
+			return Bool(false)
+		case isBool(x, true):
+			return y
+		case isBool(y, true):
+			return x
+		}
+		return And{x, y}
+	case Or:
+		x, y := optExp(e[0]), optExp(e[1])
+		switch {
+		case isBool(x, true) || isBool(y, true):
+			return Bool(true)
+		case isBool(x, false):
+			return y
+		case isBool(y, false):
+			return x
+		}
+		return Or{x, y}
+	case Neg:
+		x := optExp(e[0])
+		switch x := x.(type) {
+		case Bool:
+			return !x
+		case Neg:
+			return x[0]
+		}
+		return Neg{x}
+	case Equ, Neq, Les, Leq, Gre, Geq:
+		return optCompare(e)
+	}
+	return e
+}
+
+// Comparisons of two literals are evaluated
+func optCompare(e Exp) Exp {
+	c := expChildren(e)
+	x, y := optExp(c[0]), optExp(c[1])
+	var f Exp
+	switch e.(type) {
+	case Equ:
+		f = Equ{x, y}
+	case Neq:
+		f = Neq{x, y}
+	case Les:
+		f = Les{x, y}
+	case Leq:
+		f = Leq{x, y}
+	case Gre:
+		f = Gre{x, y}
+	default:
+		f = Geq{x, y}
+	}
+	if isLiteral(x) && isLiteral(y) {
+		v := f.eval(ValState{})
+		if v.flag == ValueBool {
+			return Bool(v.valB)
+		}
+	}
+	return f
+}
+
+func isLiteral(e Exp) bool {
+	switch e.(type) {
+	case Num, Bool:
+		return true
+	}
+	return false
+}
+
+func testOpt(s string) {
+	stmt, errorAt, e := parse(s)
+	fmt.Printf("\n Input: %s", s)
+	if !stmt {
+		fmt.Printf("\n ERROR ON PARSE \n AT CHARACTER %d \n", errorAt)
+		return
+	}
+	fmt.Printf("\n Output Parse: %s", e.pretty())
+	o := optimize(e)
+	fmt.Printf("\n Optimized: %s", o.pretty())
+	fmt.Printf("\n Evalutaion: ")
+	e.eval(make(ValState))
+	fmt.Printf("\n Evalutaion Optimized: ")
+	o.eval(make(ValState))
+	fmt.Printf("\n")
+}
+
+func testOptimizer() {
+	fmt.Printf("\n Test 23.1 - Constant Folding - literals \n")
+	testOpt("{print 2+3*4; print 7-2 < 4; print !(true && false) == true}")
+	fmt.Printf("\n Test 23.2 - Algebraic Simplification - x*1, x+0, b && true, !!b \n")
+	testOpt("{varX:=5; varB:=true; print varX*1 + 0; print varB && true; print !(!varB); print varX*0}")
+	fmt.Printf("\n Test 23.3 - Pruning - if with constant condition, while false \n")
+	testOpt("{varX:=1; if 1 < 2 {print varX} else {print 0}; while 2 < 1 {varX = varX+1}; print varX}")
+	fmt.Printf("\n Test 23.4 - Pruning - a branch with declarations keeps its scope \n")
+	testOpt("{varX:=1; if true {varX:=2; print varX} else {varX:=3}; print varX}")
+	_, _, e := parse("{varX:=1; if true {varX:=2; print varX} else {varX:=3}; print varX}")
+	ok, _, _ := optimize(e).check(make(TyState))
+	fmt.Printf(" Check Optimized: %t \n", ok)
+	fmt.Printf("\n Test 23.5 - Pruning - while false becomes skip \n")
+	testOpt("{while false {print 1}}")
+	test("{skip; varX:=1; while varX < 3 {skip; varX = varX+1}; print varX}")
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// JSON serialization of the AST
+//
+// Schema version 1, a document is {"version": 1, "program": <Block>}. Every
+// node is an object whose "node" field names its kind:
+//
+//	{"node": "Num", "int": 3}
+//	{"node": "Bool", "bool": true}
+//	{"node": "Var", "name": "x"}
+//	{"node": "Plus", "args": [<exp>, <exp>]}      Minus Mult And Or Equ Neq Les Leq Gre Geq alike
+//	{"node": "Neg", "args": [<exp>]}
+//	{"node": "Block", "body": <stmt>}
+//	{"node": "ComS", "stmts": [<stmt>, <stmt>]}
+//	{"node": "Decl", "name": "x", "type": "int", "exp": <exp>}   "type" only if annotated
+//	{"node": "Decl", "name": "x", "type": "int", "zero": true}    var x int
+//	{"node": "Assign", "name": "x", "exp": <exp>}
+//	{"node": "Print", "exp": <exp>}
+//	{"node": "Assert", "exp": <exp>}                              Assume alike
+//	{"node": "While", "exp": <exp>, "inv": <exp>, "blocks": [<Block>]}   "inv" only if given
+//	{"node": "IfEl", "exp": <exp>, "blocks": [<Block>, <Block>]}
+//	{"node": "Skip"}
+//
+// Blocks and statements may carry "span": {"line", "col", "endLine", "endCol"}.
+// Names must be variables of the grammar: letters only and no keyword.
+// Unmarshal(Marshal(b)) is structurally equal to b.
+
+const astVersion = 1
+
+type jsonDoc struct {
+	Version int       `json:"version"`
+	Program *jsonNode `json:"program"`
+}
+
+type jsonSpan struct {
+	Line    int `json:"line"`
+	Col     int `json:"col"`
+	EndLine int `json:"endLine"`
+	EndCol  int `json:"endCol"`
+}
+
+type jsonNode struct {
+	Node   string      `json:"node"`
+	Int    *int        `json:"int,omitempty"`
+	Bool   *bool       `json:"bool,omitempty"`
+	Name   string      `json:"name,omitempty"`
+	Type   string      `json:"type,omitempty"`
+	Zero   bool        `json:"zero,omitempty"`
+	Args   []*jsonNode `json:"args,omitempty"`
+	Exp    *jsonNode   `json:"exp,omitempty"`
+	Inv    *jsonNode   `json:"inv,omitempty"`
+	Body   *jsonNode   `json:"body,omitempty"`
+	Stmts  []*jsonNode `json:"stmts,omitempty"`
+	Blocks []*jsonNode `json:"blocks,omitempty"`
+	Span   *jsonSpan   `json:"span,omitempty"`
+}
+
+func Marshal(b Block) ([]byte, error) {
+	return json.MarshalIndent(jsonDoc{astVersion, marshalBlock(b)}, "", "  ")
+}
+
+func marshalSpan(sp Span) *jsonSpan {
+	if sp.line == 0 {
+		return nil
+	}
+	return &jsonSpan{sp.line, sp.col, sp.endLine, sp.endCol}
+}
+
+func marshalBlock(b Block) *jsonNode {
+	return &jsonNode{Node: "Block", Body: marshalStmt(b.s), Span: marshalSpan(b.span)}
+}
+
+func marshalStmt(s Stmt) *jsonNode {
+	switch s := s.(type) {
+	case ComS:
+		return &jsonNode{Node: "ComS", Stmts: []*jsonNode{marshalStmt(s[0]), marshalStmt(s[1])}}
+	case Decl:
+		n := &jsonNode{Node: "Decl", Name: s.lhs, Span: marshalSpan(s.span)}
+		if s.ty != TyIllTyped {
+			n.Type = typeKeyword(s.ty)
+		}
+		if s.zero {
+			n.Zero = true
+		} else {
+			n.Exp = marshalExp(s.rhs)
+		}
+		return n
+	case Assign:
+		return &jsonNode{Node: "Assign", Name: s.name, Exp: marshalExp(s.value), Span: marshalSpan(s.span)}
+	case Print:
+		return &jsonNode{Node: "Print", Exp: marshalExp(s.e), Span: marshalSpan(s.span)}
+	case Assert:
+		return &jsonNode{Node: "Assert", Exp: marshalExp(s.e), Span: marshalSpan(s.span)}
+	case Assume:
+		return &jsonNode{Node: "Assume", Exp: marshalExp(s.e), Span: marshalSpan(s.span)}
+	case While:
+		n := &jsonNode{Node: "While", Exp: marshalExp(s.e), Blocks: []*jsonNode{marshalBlock(s.b)}, Span: marshalSpan(s.span)}
+		if s.inv != nil {
+			n.Inv = marshalExp(s.inv)
+		}
+		return n
+	case Skip:
+		return &jsonNode{Node: "Skip", Span: marshalSpan(s.span)}
+	case IfEl:
+		return &jsonNode{Node: "IfEl", Exp: marshalExp(s.e), Blocks: []*jsonNode{marshalBlock(s.b1), marshalBlock(s.b2)}, Span: marshalSpan(s.span)}
+	}
+	return &jsonNode{Node: kindOf(s)}
+}
+
+func marshalExp(e Exp) *jsonNode {
+	n := &jsonNode{Node: kindOf(e)}
+	switch e := e.(type) {
+	case Num:
+		i := int(e)
+		n.Int = &i
+	case Bool:
+		b := bool(e)
+		n.Bool = &b
+	case Var:
+		n.Name = string(e)
+	}
+	for _, f := range expChildren(e) {
+		n.Args = append(n.Args, marshalExp(f))
+	}
+	return n
+}
+
+func Unmarshal(data []byte) (Block, error) {
+	// The schema has no expression spans
+	expSpans = nil
+	var doc jsonDoc
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return Block{}, err
+	}
+	if doc.Version != astVersion {
+		return Block{}, fmt.Errorf("unsupported AST version %d, expected %d", doc.Version, astVersion)
+	}
+	if doc.Program == nil {
+		return Block{}, fmt.Errorf("missing program")
+	}
+	return unmarshalBlock(doc.Program)
+}
+
+func unmarshalSpan(sp *jsonSpan) Span {
+	if sp == nil {
+		return Span{}
+	}
+	return Span{sp.Line, sp.Col, sp.EndLine, sp.EndCol}
+}
+
+func unmarshalBlock(n *jsonNode) (Block, error) {
+	if n == nil || n.Node != "Block" {
+		return Block{}, fmt.Errorf("expected Block node")
+	}
+	s, err := unmarshalStmt(n.Body)
+	if err != nil {
+		return Block{}, err
+	}
+	return Block{s, unmarshalSpan(n.Span)}, nil
+}
+
+func unmarshalBlocks(n *jsonNode, count int) ([]Block, error) {
+	if len(n.Blocks) != count {
+		return nil, fmt.Errorf("%s node needs %d blocks", n.Node, count)
+	}
+	var bs []Block
+	for _, c := range n.Blocks {
+		b, err := unmarshalBlock(c)
+		if err != nil {
+			return nil, err
+		}
+		bs = append(bs, b)
+	}
+	return bs, nil
+}
+
+func unmarshalStmt(n *jsonNode) (Stmt, error) {
+	if n == nil {
+		return nil, fmt.Errorf("missing statement")
+	}
+	sp := unmarshalSpan(n.Span)
+	switch n.Node {
+	case "ComS":
+		if len(n.Stmts) != 2 {
+			return nil, fmt.Errorf("ComS node needs 2 stmts")
+		}
+		s1, err := unmarshalStmt(n.Stmts[0])
+		if err != nil {
+			return nil, err
+		}
+		s2, err := unmarshalStmt(n.Stmts[1])
+		if err != nil {
+			return nil, err
+		}
+		return ComS{s1, s2}, nil
+	case "Skip":
+		return Skip{sp}, nil
+	case "Decl", "Assign", "Print", "Assert", "Assume":
+		if n.Node != "Print" && n.Node != "Assert" && n.Node != "Assume" {
+			if err := checkName(n); err != nil {
+				return nil, err
+			}
+		}
+		if n.Zero {
+			return unmarshalZeroDecl(n, sp)
+		}
+		e, err := unmarshalExp(n.Exp)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Node {
+		case "Assign":
+			return Assign{n.Name, e, sp}, nil
+		case "Print":
+			return Print{e, sp}, nil
+		case "Assert":
+			return Assert{e, sp}, nil
+		case "Assume":
+			return Assume{e, sp}, nil
+		}
+		ty := TyIllTyped
+		switch n.Type {
+		case "":
+		case "int":
+			ty = TyInt
+		case "bool":
+			ty = TyBool
+		default:
+			return nil, fmt.Errorf("unknown type %s", n.Type)
+		}
+		return Decl{n.Name, e, ty, false, sp}, nil
+	case "While", "IfEl":
+		e, err := unmarshalExp(n.Exp)
+		if err != nil {
+			return nil, err
+		}
+		if n.Node == "While" {
+			bs, err := unmarshalBlocks(n, 1)
+			if err != nil {
+				return nil, err
+			}
+			var inv Exp
+			if n.Inv != nil {
+				inv, err = unmarshalExp(n.Inv)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return While{e, bs[0], inv, sp}, nil
+		}
+		bs, err := unmarshalBlocks(n, 2)
+		if err != nil {
+			return nil, err
+		}
+		return IfEl{e, bs[0], bs[1], sp}, nil
+	}
+	return nil, fmt.Errorf("unknown statement node %q", n.Node)
+}
+
+// The name of n must be one the scanner reads as a variable, the backends
+// copy it into their output
+func checkName(n *jsonNode) error {
+	if n.Name == "" {
+		return fmt.Errorf("%s node needs a name", n.Node)
+	}
+	for i := 0; i < len(n.Name); i++ {
+		if c := n.Name[i]; !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			return fmt.Errorf("%s node has the invalid name %q", n.Node, n.Name)
+		}
+	}
+	if rest, tok := scan(n.Name + ";"); tok != VAR || rest != ";" {
+		return fmt.Errorf("%s node has the keyword %q as name", n.Node, n.Name)
+	}
+	return nil
+}
+
+// var x T, the type is required and the zero value is implied
+func unmarshalZeroDecl(n *jsonNode, sp Span) (Stmt, error) {
+	if n.Node != "Decl" {
+		return nil, fmt.Errorf("%s node cannot be zero", n.Node)
+	}
+	if n.Exp != nil {
+		return nil, fmt.Errorf("zero Decl node cannot have an exp")
+	}
+	switch n.Type {
+	case "int":
+		return Decl{n.Name, zeroExp(TyInt), TyInt, true, sp}, nil
+	case "bool":
+		return Decl{n.Name, zeroExp(TyBool), TyBool, true, sp}, nil
+	}
+	return nil, fmt.Errorf("zero Decl node needs the type int or bool")
+}
+
+func unmarshalExp(n *jsonNode) (Exp, error) {
+	if n == nil {
+		return nil, fmt.Errorf("missing expression")
+	}
+	switch n.Node {
+	case "Num":
+		if n.Int == nil {
+			return nil, fmt.Errorf("Num node needs an int")
+		}
+		return Num(*n.Int), nil
+	case "Bool":
+		if n.Bool == nil {
+			return nil, fmt.Errorf("Bool node needs a bool")
+		}
+		return Bool(*n.Bool), nil
+	case "Var":
+		if err := checkName(n); err != nil {
+			return nil, err
+		}
+		return Var(n.Name), nil
+	}
+	mk, ok := binaryNodes[n.Node]
+	if !ok && n.Node != "Neg" {
+		return nil, fmt.Errorf("unknown expression node %q", n.Node)
+	}
+	var args []Exp
+	for _, c := range n.Args {
+		e, err := unmarshalExp(c)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+	}
+	if n.Node == "Neg" {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("Neg node needs 1 arg")
+		}
+		return Neg{args[0]}, nil
+	}
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%s node needs 2 args", n.Node)
+	}
+	return mk([2]Exp{args[0], args[1]}), nil
+}
+
+var binaryNodes = map[string]func([2]Exp) Exp{
+	"Plus":  func(x [2]Exp) Exp { return Plus(x) },
+	"Minus": func(x [2]Exp) Exp { return Minus(x) },
+	"Mult":  func(x [2]Exp) Exp { return Mult(x) },
+	"And":   func(x [2]Exp) Exp { return And(x) },
+	"Or":    func(x [2]Exp) Exp { return Or(x) },
+	"Equ":   func(x [2]Exp) Exp { return Equ(x) },
+	"Neq":   func(x [2]Exp) Exp { return Neq(x) },
+	"Les":   func(x [2]Exp) Exp { return Les(x) },
+	"Leq":   func(x [2]Exp) Exp { return Leq(x) },
+	"Gre":   func(x [2]Exp) Exp { return Gre(x) },
+	"Geq":   func(x [2]Exp) Exp { return Geq(x) },
+}
+
+// A JSON document starts with {" where a program starts with { and a statement
+func isJSONProgram(src string) bool {
+	src = strings.TrimLeft(src, " \t\r\n")
+	return strings.HasPrefix(src, "{") && strings.HasPrefix(strings.TrimLeft(src[1:], " \t\r\n"), "\"")
+}
+
+func testRoundTrip(s string) {
+	stmt, errorAt, e := parse(s)
+	fmt.Printf("\n Input: %s", s)
+	if !stmt {
+		fmt.Printf("\n ERROR ON PARSE \n AT CHARACTER %d \n", errorAt)
+		return
+	}
+	fmt.Printf("\n Output Parse: %s", e.pretty())
+	data, err := Marshal(e)
+	if err != nil {
+		fmt.Printf("\n ERROR ON MARSHAL %s \n", err)
+		return
+	}
+	e2, err := Unmarshal(data)
+	if err != nil {
+		fmt.Printf("\n ERROR ON UNMARSHAL %s \n", err)
+		return
+	}
+	fmt.Printf("\n Round Trip: %t \n", reflect.DeepEqual(e, e2))
+}
+
+func testUnmarshal(s string) {
+	fmt.Printf("\n Input: %s", s)
+	e, err := Unmarshal([]byte(s))
+	if err != nil {
+		fmt.Printf("\n ERROR ON UNMARSHAL \n %s \n", err)
+		return
+	}
+	fmt.Printf("\n Output Unmarshal: %s", e.pretty())
+	errorDetail = ""
+	exp, errorIn, errorAtExp := e.check(make(TyState))
+	fmt.Printf("\n Check: %t ", exp)
+	if !exp {
+		fmt.Printf("\n %s\n", illTypedMessage(errorIn, errorAtExp))
+		return
+	}
+	fmt.Printf("\n Evalutaion: ")
+	e.eval(make(ValState))
+	fmt.Printf("\n")
+}
+
+func testSerialize() {
+	fmt.Printf("\n Test 27.1 - JSON - round trip \n")
+	testRoundTrip("{varX:=3;varY:=4;print varX+varY}")
+	testRoundTrip("{varX : int := 1; var varB bool; while varX<4 {if !varB {print varX} else {print 0}; varX = varX+1}}")
+	fmt.Printf("\n Test 27.2 - JSON - check and eval a generated AST \n")
+	testUnmarshal(`{"version": 1, "program": {"node": "Block", "body": {"node": "ComS", "stmts": [` +
+		`{"node": "Decl", "name": "x", "exp": {"node": "Num", "int": 20}},` +
+		`{"node": "Print", "exp": {"node": "Mult", "args": [{"node": "Var", "name": "x"}, {"node": "Num", "int": 21}]}}]}}}`)
+	fmt.Printf("\n Test 27.3 - False JSON - unknown version and node \n")
+	testUnmarshal(`{"version": 2, "program": {"node": "Block", "body": {"node": "Skip"}}}`)
+	testUnmarshal(`{"version": 1, "program": {"node": "Block", "body": {"node": "Print", "exp": {"node": "Div", "args": []}}}}`)
+	fmt.Printf("\n Test 27.4 - False JSON - names that are no variables \n")
+	testUnmarshal(`{"version": 1, "program": {"node": "Block", "body": {"node": "Decl", "name": "x; puts(\"pwned\"); int64_t y", "exp": {"node": "Num", "int": 1}}}}`)
+	testUnmarshal(`{"version": 1, "program": {"node": "Block", "body": {"node": "Assign", "name": "while", "exp": {"node": "Num", "int": 1}}}}`)
+	testUnmarshal(`{"version": 1, "program": {"node": "Block", "body": {"node": "Print", "exp": {"node": "Var", "name": "x1"}}}}`)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Symbolic execution
+//
+// The declarations of the input variables bind a symbol named after the
+// variable instead of the value of their initializer, every other variable
+// holds an expression over the symbols. A condition over symbols forks the
+// path if both outcomes are feasible under the path condition, the branch
+// conditions taken so far. While is unrolled at most bound times per path, a
+// path that could go on looping ends with "loop bound reached". An assert
+// that can fail ends a path of its own. Every finished path gets witness
+// inputs from the linear solver, rerunning eval with them follows the path.
+
+type symPath struct {
+	st      map[string]Exp // value of every variable
+	pc      []Exp          // branch conditions taken
+	prints  []Exp
+	end     string // how the path ends, "" if it runs to the end
+	span    Span   // of the assert or loop that ended the path
+	witness ValState
+	status  satResult
+}
+
+func (p symPath) fork() symPath {
+	q := symPath{st: map[string]Exp{}, end: p.end, span: p.span}
+	for x, e := range p.st {
+		q.st[x] = e
+	}
+	q.pc = append(q.pc, p.pc...)
+	q.prints = append(q.prints, p.prints...)
+	return q
+}
+
+// Conjunction of the path condition
+func (p symPath) condition() Exp {
+	var f Exp = Bool(true)
+	for _, c := range p.pc {
+		f = conj(f, c)
+	}
+	return f
+}
+
+type symExec struct {
+	inputs map[string]bool
+	types  TyState // types of the symbols
+	bound  int
+	done   []symPath
+	// Paths are dropped once maxSymPaths of them are done
+	truncated bool
+	// A loop sets the variables it assigns to fresh symbols instead of
+	// being unrolled
+	havocLoops bool
+	fresh      int
+}
+
+const maxSymPaths = 256
+
+// Symbolic paths of b with the named inputs, loops unrolled at most bound
+// times. The witness of each path holds every input.
+func symbolicPaths(b Block, inputs []string, bound int) ([]symPath, bool) {
+	x := &symExec{inputs: map[string]bool{}, types: TyState{}, bound: bound}
+	for _, in := range inputs {
+		x.inputs[in] = true
+	}
+	for _, p := range x.exec(b.s, symPath{st: map[string]Exp{}}) {
+		x.finish(p)
+	}
+	for i := range x.done {
+		p := &x.done[i]
+		p.witness, p.status = solve(p.condition(), x.types)
+		if p.status != satYes {
+			p.witness = nil
+			continue
+		}
+		// Inputs the path does not constrain keep the value of their type
+		for in, ty := range x.types {
+			if _, ok := p.witness[in]; !ok {
+				p.witness[in] = zeroExp(ty).eval(ValState{})
+			}
+		}
+	}
+	return x.done, x.truncated
+}
+
+func (x *symExec) finish(p symPath) {
+	if len(x.done) >= maxSymPaths {
+		x.truncated = true
+		return
+	}
+	x.done = append(x.done, p)
+}
+
+// e with the values of its variables
+func (x *symExec) value(e Exp, p symPath) Exp {
+	return optExp(substAll(e, p.st))
+}
+
+// e with every variable x of m replaced by m[x] at once
+func substAll(e Exp, m map[string]Exp) Exp {
+	switch e := e.(type) {
+	case Var:
+		if r, ok := m[string(e)]; ok {
+			return r
+		}
+		return e
+	case Neg:
+		return Neg{substAll(e[0], m)}
+	}
+	c := expChildren(e)
+	if len(c) == 2 {
+		return binaryNodes[kindOf(e)]([2]Exp{substAll(c[0], m), substAll(c[1], m)})
+	}
+	return e
+}
+
+// The paths through c being true and false, nil if infeasible
+func (x *symExec) split(p symPath, c Exp) (*symPath, *symPath) {
+	if b, ok := c.(Bool); ok {
+		if b {
+			return &p, nil
+		}
+		return nil, &p
+	}
+	var t, f *symPath
+	if x.feasible(p, c) {
+		q := p.fork()
+		q.pc = append(q.pc, c)
+		t = &q
+	}
+	if x.feasible(p, Neg{c}) {
+		p.pc = append(p.pc, optExp(Neg{c}))
+		f = &p
+	}
+	return t, f
+}
+
+// False only if the solver shows that c cannot hold on p
+func (x *symExec) feasible(p symPath, c Exp) bool {
+	_, r := solve(conj(p.condition(), c), x.types)
+	return r != satNo
+}
+
+// Paths after s, paths ending in s are added to x.done
+func (x *symExec) exec(s Stmt, p symPath) []symPath {
+	if len(x.done) >= maxSymPaths {
+		x.truncated = true
+		return nil
+	}
+	switch s := s.(type) {
+	case ComS:
+		var out []symPath
+		for _, q := range x.exec(s[0], p) {
+			out = append(out, x.exec(s[1], q)...)
+		}
+		return out
+	case Decl:
+		v := x.value(s.rhs, p)
+		if x.inputs[s.lhs] {
+			ty := s.ty
+			if ty == TyIllTyped {
+				ty, _ = v.infer(x.types)
+			}
+			x.types[s.lhs] = ty
+			v = Var(s.lhs)
+		}
+		p.st[s.lhs] = v
+	case Assign:
+		p.st[s.name] = x.value(s.value, p)
+	case Print:
+		p.prints = append(p.prints, x.value(s.e, p))
+	case Assert:
+		t, f := x.split(p, x.value(s.e, p))
+		if f != nil {
+			f.end = "assertion failed: " + s.e.pretty()
+			f.span = s.span
+			x.finish(*f)
+		}
+		if t == nil {
+			return nil
+		}
+		return []symPath{*t}
+	case Assume:
+		t, _ := x.split(p, x.value(s.e, p))
+		if t == nil {
+			return nil
+		}
+		return []symPath{*t}
+	case IfEl:
+		var out []symPath
+		t, f := x.split(p, x.value(s.e, p))
+		if t != nil {
+			out = append(out, x.exec(s.b1.s, *t)...)
+		}
+		if f != nil {
+			out = append(out, x.exec(s.b2.s, *f)...)
+		}
+		return out
+	case While:
+		if x.havocLoops {
+			return x.havoc(s, p)
+		}
+		return x.loop(s, p, 0)
+	}
+	return []symPath{p}
+}
+
+// The path after w for any number of iterations, the condition is false
+func (x *symExec) havoc(w While, p symPath) []symPath {
+	for _, v := range assignedVars(w.b.s) {
+		old, ok := p.st[v]
+		if !ok {
+			continue
+		}
+		x.fresh++
+		sym := fmt.Sprintf("%s#%d", v, x.fresh)
+		x.types[sym], _ = old.infer(x.types)
+		p.st[v] = Var(sym)
+	}
+	_, f := x.split(p, x.value(w.e, p))
+	if f == nil {
+		return nil
+	}
+	return []symPath{*f}
+}
+
+// Paths leaving w after at most bound - k more iterations
+func (x *symExec) loop(w While, p symPath, k int) []symPath {
+	var out []symPath
+	t, f := x.split(p, x.value(w.e, p))
+	if f != nil {
+		out = append(out, *f)
+	}
+	if t == nil {
+		return out
+	}
+	if k == x.bound {
+		t.end = "loop bound reached: while " + w.e.pretty()
+		t.span = w.span
+		x.finish(*t)
+		return out
+	}
+	for _, q := range x.exec(w.b.s, *t) {
+		out = append(out, x.loop(w, q, k+1)...)
+	}
+	return out
+}
+
+func (p symPath) pretty() string {
+	var lines []string
+	end := "ok"
+	if p.end != "" {
+		end = p.end
+		if sp := p.span.pretty(); sp != "" {
+			end += " at " + sp
+		}
+	}
+	lines = append(lines, end)
+	var conds []string
+	for _, c := range p.pc {
+		conds = append(conds, c.pretty())
+	}
+	if len(conds) == 0 {
+		conds = append(conds, "true")
+	}
+	lines = append(lines, "condition: "+strings.Join(conds, " && "))
+	if p.status == satYes {
+		lines = append(lines, "inputs: "+showInputs(p.witness))
+	} else {
+		lines = append(lines, "inputs: "+p.status.pretty())
+	}
+	var prints []string
+	for _, e := range p.prints {
+		prints = append(prints, e.pretty())
+	}
+	lines = append(lines, "prints: "+strings.Join(prints, ", "))
+	return strings.Join(lines, "\n")
+}
+
+func showInputs(w ValState) string {
+	var names []string
+	for x := range w {
+		names = append(names, x)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, x := range names {
+		parts = append(parts, x+" = "+showVal(w[x]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// b with the initializers of the inputs replaced by their witness values
+func withInputs(b Block, w ValState) Block {
+	var walk func(s Stmt) Stmt
+	walk = func(s Stmt) Stmt {
+		switch s := s.(type) {
+		case ComS:
+			return ComS{walk(s[0]), walk(s[1])}
+		case Decl:
+			v, ok := w[s.lhs]
+			if !ok {
+				return s
+			}
+			var e Exp = Num(v.valI)
+			if v.flag == ValueBool {
+				e = Bool(v.valB)
+			}
+			return Decl{s.lhs, e, s.ty, false, s.span}
+		case IfEl:
+			return IfEl{s.e, Block{walk(s.b1.s), s.b1.span}, Block{walk(s.b2.s), s.b2.span}, s.span}
+		case While:
+			return While{s.e, Block{walk(s.b.s), s.b.span}, s.inv, s.span}
+		}
+		return s
+	}
+	return Block{walk(b.s), b.span}
+}
+
+// Runs b with the witness of p, reports whether eval follows p: it prints
+// the same values and ends in the same way. A path ending at the loop bound
+// only has to print the same values first.
+func replay(b Block, p symPath) bool {
+	if p.status != satYes {
+		return false
+	}
+	var want bytes.Buffer
+	old := output
+	output = &want
+	for _, e := range p.prints {
+		Print{e, Span{}}.eval(p.witness)
+	}
+	var got bytes.Buffer
+	output = &got
+	err := evalChecked(withInputs(b, p.witness), make(ValState))
+	output = old
+	var a AssertionError
+	switch {
+	case strings.HasPrefix(p.end, "loop bound"):
+		return strings.HasPrefix(got.String(), want.String())
+	case strings.HasPrefix(p.end, "assertion failed"):
+		return errors.As(err, &a) && a.span == p.span && got.String() == want.String()
+	}
+	return err == nil && got.String() == want.String()
+}
+
+func testSymexProgram(s string, inputs []string, bound int) {
+	fmt.Printf("\n Input: %s\n Symbolic: %s, bound %d", s, strings.Join(inputs, ", "), bound)
+	_, _, b := parse(s)
+	paths, truncated := symbolicPaths(b, inputs, bound)
+	same := 0
+	for i, p := range paths {
+		fmt.Printf("\n path %d: %s", i+1, strings.ReplaceAll(p.pretty(), "\n", "\n   "))
+		if replay(b, p) {
+			same++
+		}
+	}
+	if truncated {
+		fmt.Printf("\n more than %d paths, the rest was dropped", maxSymPaths)
+	}
+	fmt.Printf("\n Replayed with eval: %d of %d witnesses follow their path\n", same, len(paths))
+}
+
+func testSolve(s string, t TyState) {
+	_, _, b := parse("{print " + s + "}")
+	f := b.s.(Print).e
+	model, r := solve(f, t)
+	fmt.Printf("\n %-40s %-7s %s", f.pretty(), r.pretty(), showInputs(model))
+}
+
+func testSymex() {
+	fmt.Printf("\n Test 39.1 - Symbolic Execution - branches \n")
+	testSymexProgram("{varX:=0; varY:=0; if varX < varY+2 {print varX} else {if varX-varY == 7 {print 1} else {print varY}}}", []string{"varX", "varY"}, 4)
+	fmt.Printf("\n Test 39.2 - Symbolic Execution - bounded unrolling of while \n")
+	testSymexProgram("{varN:=0; varI:=0; varS:=0; while varI < varN {varS = varS+varI; varI = varI+1}; print varS}", []string{"varN"}, 3)
+	fmt.Printf("\n Test 39.3 - Symbolic Execution - inputs that make an assertion fail \n")
+	testSymexProgram("{varX:=0; varY:=varX*2+1; if varY > 9 {assert varX != 7} else {print varY}}", []string{"varX"}, 4)
+	fmt.Printf("\n Test 39.4 - Symbolic Execution - bool inputs and assume \n")
+	testSymexProgram("{varB:=true; varX:=0; assume varX >= 0; if varB && varX > 3 || !varB == (varX == 2) {print 1} else {print 0}}", []string{"varB", "varX"}, 4)
+	fmt.Printf("\n Test 39.5 - Symbolic Execution - nonlinear conditions \n")
+	testSymexProgram("{varX:=0; if varX*varX == 4 {print varX} else {print 0}}", []string{"varX"}, 4)
+	fmt.Printf("\n Test 39.6 - Linear Solver \n")
+	ints := TyState{"varX": TyInt, "varY": TyInt, "varZ": TyInt}
+	testSolve("varX+varY == 7 && varX-varY == 3", ints)
+	testSolve("2*varX == 1", ints)
+	testSolve("varX < varY && varY < varZ && varZ < varX+2", ints)
+	testSolve("3*varX+5 <= 2*varY && varY <= 4 && varX >= 0-9", ints)
+	testSolve("varX != varY && varX >= 3 && varY >= varX", ints)
+	testSolve("varX*varY == 6", ints)
+	fmt.Printf("\n")
+}
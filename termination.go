@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Termination hints
+//
+// Every while loop is checked on its own. A loop whose condition is always
+// true never ends, IMP has no other way out of a loop. A loop whose body
+// assigns none of the variables of its condition never ends once it is
+// entered. Otherwise the body is executed symbolically from any state in
+// which the condition holds, nested loops set the variables they assign to
+// unknown values. The loop terminates if after every path through the body
+// the condition is false, or if a comparison of the condition, lo < hi or
+// lo <= hi, gives a ranking function hi - lo that every path decreases: it
+// stays at least 0 while the loop runs. Other loops may not terminate.
+// Overflows are not considered.
+
+type loopResult struct {
+	loop    While
+	ranking Exp  // hi - lo, nil if none was found
+	once    bool // the body always makes the condition false
+	diag    *Diagnostic
+}
+
+// Results for the loops of b in source order
+func loopResults(b Block) []loopResult {
+	var rs []loopResult
+	var walk func(s Stmt, t TyState)
+	walk = func(s Stmt, t TyState) {
+		switch s := s.(type) {
+		case ComS:
+			walk(s[0], copyTyState(t))
+			typeStmt(s[0], t)
+			walk(s[1], t)
+		case IfEl:
+			walk(s.b1.s, copyTyState(t))
+			walk(s.b2.s, copyTyState(t))
+		case While:
+			rs = append(rs, loopTermination(s, t))
+			walk(s.b.s, copyTyState(t))
+		}
+	}
+	walk(b.s, TyState{})
+	return rs
+}
+
+// Warnings about loops that do not or may not terminate
+func termination(b Block) []Diagnostic {
+	var ds []Diagnostic
+	for _, r := range loopResults(b) {
+		if r.diag != nil {
+			ds = append(ds, *r.diag)
+		}
+	}
+	return ds
+}
+
+// Checks w, t holds the types of the variables before it
+func loopTermination(w While, t TyState) loopResult {
+	r := loopResult{loop: w}
+	loop := "while " + w.e.pretty()
+	c := optExp(w.e)
+	switch {
+	case isBool(c, false):
+		return r
+	case isBool(c, true):
+		d := warning("loop never terminates: " + loop)
+		r.diag = &d
+		return r
+	}
+	assigned := map[string]bool{}
+	for _, x := range assignedVars(w.b.s) {
+		assigned[x] = true
+	}
+	vars := expVars(c)
+	changed := false
+	for _, x := range vars {
+		changed = changed || assigned[x]
+	}
+	if !changed {
+		d := warning("loop never terminates once entered: " + loop + ", the body assigns none of " + strings.Join(vars, ", "))
+		r.diag = &d
+		return r
+	}
+	// Every variable starts as the symbol of its value at the head
+	x := &symExec{types: copyTyState(t), havocLoops: true}
+	start := symPath{st: map[string]Exp{}, pc: []Exp{c}}
+	for v := range t {
+		start.st[v] = Var(v)
+	}
+	paths := x.exec(w.b.s, start)
+	if !x.truncated {
+		r.once = true
+		for _, p := range paths {
+			r.once = r.once && x.never(p, x.value(w.e, p))
+		}
+		if r.once {
+			return r
+		}
+		for _, rank := range rankings(c) {
+			decreases := true
+			for _, p := range paths {
+				decreases = decreases && x.never(p, Geq{x.value(rank, p), rank})
+			}
+			if decreases {
+				r.ranking = rank
+				return r
+			}
+		}
+	}
+	d := warning("loop may not terminate: " + loop + ", no ranking function found")
+	r.diag = &d
+	return r
+}
+
+// True if the solver shows that e cannot hold at the end of p
+func (x *symExec) never(p symPath, e Exp) bool {
+	_, res := solve(conj(p.condition(), e), x.types)
+	return res == satNo
+}
+
+// hi - lo for the comparisons lo < hi and lo <= hi among the conjuncts of c
+func rankings(c Exp) []Exp {
+	var rs []Exp
+	var walk func(e Exp)
+	walk = func(e Exp) {
+		switch e := e.(type) {
+		case And:
+			walk(e[0])
+			walk(e[1])
+		case Les:
+			rs = append(rs, optExp(Minus{e[1], e[0]}))
+		case Leq:
+			rs = append(rs, optExp(Minus{e[1], e[0]}))
+		case Gre:
+			rs = append(rs, optExp(Minus{e[0], e[1]}))
+		case Geq:
+			rs = append(rs, optExp(Minus{e[0], e[1]}))
+		}
+	}
+	walk(c)
+	return rs
+}
+
+func (r loopResult) pretty() string {
+	x := "while " + r.loop.e.pretty() + ": "
+	switch {
+	case r.diag != nil:
+		return x + r.diag.pretty()
+	case r.ranking != nil:
+		return x + "terminates, ranking function " + r.ranking.pretty()
+	case r.once:
+		return x + "terminates, the body makes the condition false"
+	}
+	return x + "not entered"
+}
+
+func testTerminationProgram(s string) {
+	fmt.Printf("\n Input: %s", s)
+	_, _, b := parse(s)
+	for _, r := range loopResults(b) {
+		fmt.Printf("\n %s", r.pretty())
+	}
+	fmt.Printf("\n")
+}
+
+func testTermination() {
+	fmt.Printf("\n Test 40.1 - Termination - ranking functions \n")
+	testTerminationProgram("{varI:=0; varN:=9; while varI < varN {varI = varI+1}}")
+	testTerminationProgram("{varX:=9; while varX > 0 && varX != 4 {varX = varX-2}}")
+	testTerminationProgram("{varI:=0; varJ:=9; while varI <= varJ {if varI < 3 {varI = varI+1} else {varJ = varJ-1}}}")
+	testTerminationProgram("{varX:=true; while varX {print varX; varX = false}}")
+	fmt.Printf("\n Test 40.2 - Termination - loops that never terminate \n")
+	testTerminationProgram("{varX:=1; while 1<4 {print varX; varX = varX+1}}")
+	testTerminationProgram("{varX:=0; varN:=5; while varX < varN {print varX}}")
+	fmt.Printf("\n Test 40.3 - Termination - loops that may not terminate \n")
+	testTerminationProgram("{varI:=0; varS:=1; while varI < 9 {varI = varI+varS}}")
+	testTerminationProgram("{varx:=6; vary:=3; varf:=varx < vary; while vary < varx {if varf {vary = vary+1; varf = !varf} else {varf = !varf}}}")
+	testTerminationProgram("{varI:=0; while varI != 9 {varI = varI+2}}")
+	fmt.Printf("\n Test 40.4 - Termination - nested loops \n")
+	testTerminationProgram("{varI:=0; while varI < 5 {varJ:=0; while varJ < varI {varJ = varJ+1}; varI = varI+1}}")
+	testTerminationProgram("{varI:=0; varJ:=0; while varI < 5 {while varJ < 3 {varJ = varJ+1; varI = varI-1}; varI = varI+1}}")
+}
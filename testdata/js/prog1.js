@@ -0,0 +1,11 @@
+// Code generated by imp build -target=js. DO NOT EDIT.
+
+export function main(print = (v) => console.log(String(v))) {
+  let varX_int = 0n;
+  let varY_int = 0n;
+  varX_int = 3n;
+  varY_int = 4n;
+  print(BigInt.asIntN(64, varX_int + varY_int));
+  print(BigInt.asIntN(64, varX_int - varY_int));
+  print(BigInt.asIntN(64, varX_int * varY_int));
+}
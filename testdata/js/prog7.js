@@ -0,0 +1,14 @@
+// Code generated by imp build -target=js. DO NOT EDIT.
+
+export function main(print = (v) => console.log(String(v))) {
+  let varX_int = 0n;
+  let varI_int = 0n;
+  varX_int = 3n;
+  varI_int = 0n;
+  while (varI_int < BigInt.asIntN(64, 8n * 5n)) {
+    varX_int = BigInt.asIntN(64, BigInt.asIntN(64, varX_int * 7n) + 3n);
+    varI_int = BigInt.asIntN(64, varI_int + 1n);
+  }
+  print(varX_int);
+  print((varX_int < 0n));
+}
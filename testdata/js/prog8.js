@@ -0,0 +1,16 @@
+// Code generated by imp build -target=js. DO NOT EDIT.
+
+export function main(print = (v) => console.log(String(v))) {
+  let varX_int = 0n;
+  varX_int = 1n;
+  print(varX_int);
+  if (!(varX_int === 1n)) {
+    throw new Error("1:23-1:39: assertion failed: (varX==1)");
+  }
+  varX_int = BigInt.asIntN(64, varX_int + 1n);
+  print(varX_int);
+  if (!(varX_int === 1n)) {
+    throw new Error("1:68-1:84: assertion failed: (varX==1)");
+  }
+  print(5n);
+}
@@ -0,0 +1,27 @@
+@.fmt_int = private unnamed_addr constant [5 x i8] c"%ld\0A\00"
+@.true = private unnamed_addr constant [5 x i8] c"true\00"
+@.false = private unnamed_addr constant [6 x i8] c"false\00"
+
+declare i32 @printf(i8*, ...)
+declare i32 @puts(i8*)
+
+define i32 @main() {
+entry:
+  %varX_int = alloca i64
+  %varY_int = alloca i64
+  store i64 3, i64* %varX_int
+  store i64 4, i64* %varY_int
+  %t1 = load i64, i64* %varX_int
+  %t2 = load i64, i64* %varY_int
+  %t3 = add i64 %t1, %t2
+  call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0), i64 %t3)
+  %t4 = load i64, i64* %varX_int
+  %t5 = load i64, i64* %varY_int
+  %t6 = sub i64 %t4, %t5
+  call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0), i64 %t6)
+  %t7 = load i64, i64* %varX_int
+  %t8 = load i64, i64* %varY_int
+  %t9 = mul i64 %t7, %t8
+  call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0), i64 %t9)
+  ret i32 0
+}
@@ -0,0 +1,26 @@
+@.fmt_int = private unnamed_addr constant [5 x i8] c"%ld\0A\00"
+@.true = private unnamed_addr constant [5 x i8] c"true\00"
+@.false = private unnamed_addr constant [6 x i8] c"false\00"
+
+declare i32 @printf(i8*, ...)
+declare i32 @puts(i8*)
+
+define i32 @main() {
+entry:
+  %varX_int = alloca i64
+  store i64 1, i64* %varX_int
+  br label %cond1
+cond1:
+  %t1 = load i64, i64* %varX_int
+  %t2 = icmp slt i64 %t1, 4
+  br i1 %t2, label %body1, label %done1
+body1:
+  %t3 = load i64, i64* %varX_int
+  call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0), i64 %t3)
+  %t4 = load i64, i64* %varX_int
+  %t5 = add i64 %t4, 1
+  store i64 %t5, i64* %varX_int
+  br label %cond1
+done1:
+  ret i32 0
+}
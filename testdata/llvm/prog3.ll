@@ -0,0 +1,34 @@
+@.fmt_int = private unnamed_addr constant [5 x i8] c"%ld\0A\00"
+@.true = private unnamed_addr constant [5 x i8] c"true\00"
+@.false = private unnamed_addr constant [6 x i8] c"false\00"
+
+declare i32 @printf(i8*, ...)
+declare i32 @puts(i8*)
+
+define i32 @main() {
+entry:
+  %varX_bool = alloca i1
+  %varY_int = alloca i64
+  store i1 true, i1* %varX_bool
+  %t1 = load i1, i1* %varX_bool
+  br i1 %t1, label %then1, label %else1
+then1:
+  store i64 1, i64* %varY_int
+  br label %end1
+else1:
+  store i64 2, i64* %varY_int
+  br label %end1
+end1:
+  %t2 = load i64, i64* %varY_int
+  call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0), i64 %t2)
+  %t3 = load i1, i1* %varX_bool
+  %t4 = xor i1 %t3, true
+  %t5 = select i1 %t4, i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.true, i64 0, i64 0), i8* getelementptr inbounds ([6 x i8], [6 x i8]* @.false, i64 0, i64 0)
+  call i32 @puts(i8* %t5)
+  %t6 = load i1, i1* %varX_bool
+  %t7 = and i1 %t6, false
+  %t8 = or i1 %t7, true
+  %t9 = select i1 %t8, i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.true, i64 0, i64 0), i8* getelementptr inbounds ([6 x i8], [6 x i8]* @.false, i64 0, i64 0)
+  call i32 @puts(i8* %t9)
+  ret i32 0
+}
@@ -0,0 +1,34 @@
+@.fmt_int = private unnamed_addr constant [5 x i8] c"%ld\0A\00"
+@.true = private unnamed_addr constant [5 x i8] c"true\00"
+@.false = private unnamed_addr constant [6 x i8] c"false\00"
+
+declare i32 @printf(i8*, ...)
+declare i32 @puts(i8*)
+
+define i32 @main() {
+entry:
+  %varX_int = alloca i64
+  store i64 5, i64* %varX_int
+  %t1 = load i64, i64* %varX_int
+  %t2 = icmp sle i64 %t1, 5
+  %t3 = select i1 %t2, i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.true, i64 0, i64 0), i8* getelementptr inbounds ([6 x i8], [6 x i8]* @.false, i64 0, i64 0)
+  call i32 @puts(i8* %t3)
+  %t4 = load i64, i64* %varX_int
+  %t5 = icmp sgt i64 %t4, 5
+  %t6 = select i1 %t5, i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.true, i64 0, i64 0), i8* getelementptr inbounds ([6 x i8], [6 x i8]* @.false, i64 0, i64 0)
+  call i32 @puts(i8* %t6)
+  %t7 = load i64, i64* %varX_int
+  %t8 = icmp sge i64 %t7, 6
+  %t9 = select i1 %t8, i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.true, i64 0, i64 0), i8* getelementptr inbounds ([6 x i8], [6 x i8]* @.false, i64 0, i64 0)
+  call i32 @puts(i8* %t9)
+  %t10 = load i64, i64* %varX_int
+  %t11 = icmp ne i64 %t10, 4
+  %t12 = select i1 %t11, i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.true, i64 0, i64 0), i8* getelementptr inbounds ([6 x i8], [6 x i8]* @.false, i64 0, i64 0)
+  call i32 @puts(i8* %t12)
+  %t13 = load i64, i64* %varX_int
+  %t14 = icmp eq i64 %t13, 5
+  %t15 = icmp eq i1 %t14, true
+  %t16 = select i1 %t15, i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.true, i64 0, i64 0), i8* getelementptr inbounds ([6 x i8], [6 x i8]* @.false, i64 0, i64 0)
+  call i32 @puts(i8* %t16)
+  ret i32 0
+}
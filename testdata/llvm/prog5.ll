@@ -0,0 +1,40 @@
+@.fmt_int = private unnamed_addr constant [5 x i8] c"%ld\0A\00"
+@.true = private unnamed_addr constant [5 x i8] c"true\00"
+@.false = private unnamed_addr constant [6 x i8] c"false\00"
+
+declare i32 @printf(i8*, ...)
+declare i32 @puts(i8*)
+
+define i32 @main() {
+entry:
+  %varN_int = alloca i64
+  %varA_int = alloca i64
+  %varB_int = alloca i64
+  %varT_int = alloca i64
+  store i64 0, i64* %varN_int
+  store i64 9, i64* %varN_int
+  store i64 0, i64* %varA_int
+  store i64 1, i64* %varB_int
+  br label %cond1
+cond1:
+  %t1 = load i64, i64* %varN_int
+  %t2 = icmp slt i64 0, %t1
+  br i1 %t2, label %body1, label %done1
+body1:
+  %t3 = load i64, i64* %varA_int
+  %t4 = load i64, i64* %varB_int
+  %t5 = add i64 %t3, %t4
+  store i64 %t5, i64* %varT_int
+  %t6 = load i64, i64* %varB_int
+  store i64 %t6, i64* %varA_int
+  %t7 = load i64, i64* %varT_int
+  store i64 %t7, i64* %varB_int
+  %t8 = load i64, i64* %varN_int
+  %t9 = sub i64 %t8, 1
+  store i64 %t9, i64* %varN_int
+  br label %cond1
+done1:
+  %t10 = load i64, i64* %varA_int
+  call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0), i64 %t10)
+  ret i32 0
+}
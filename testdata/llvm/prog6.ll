@@ -0,0 +1,44 @@
+@.fmt_int = private unnamed_addr constant [5 x i8] c"%ld\0A\00"
+@.true = private unnamed_addr constant [5 x i8] c"true\00"
+@.false = private unnamed_addr constant [6 x i8] c"false\00"
+
+declare i32 @printf(i8*, ...)
+declare i32 @puts(i8*)
+
+define i32 @main() {
+entry:
+  %varX_int = alloca i64
+  %varY_bool = alloca i1
+  %varY_int = alloca i64
+  store i64 1, i64* %varX_int
+  br label %cond1
+cond1:
+  %t1 = load i64, i64* %varX_int
+  %t2 = icmp slt i64 %t1, 4
+  br i1 %t2, label %body1, label %done1
+body1:
+  %t3 = load i64, i64* %varX_int
+  %t4 = icmp eq i64 %t3, 2
+  store i1 %t4, i1* %varY_bool
+  %t5 = load i1, i1* %varY_bool
+  br i1 %t5, label %then2, label %else2
+then2:
+  %t6 = load i64, i64* %varX_int
+  call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0), i64 %t6)
+  br label %end2
+else2:
+  %t7 = load i1, i1* %varY_bool
+  %t8 = select i1 %t7, i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.true, i64 0, i64 0), i8* getelementptr inbounds ([6 x i8], [6 x i8]* @.false, i64 0, i64 0)
+  call i32 @puts(i8* %t8)
+  br label %end2
+end2:
+  %t9 = load i64, i64* %varX_int
+  %t10 = add i64 %t9, 1
+  store i64 %t10, i64* %varX_int
+  br label %cond1
+done1:
+  store i64 3, i64* %varY_int
+  %t11 = load i64, i64* %varY_int
+  call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0), i64 %t11)
+  ret i32 0
+}
@@ -0,0 +1,37 @@
+@.fmt_int = private unnamed_addr constant [5 x i8] c"%ld\0A\00"
+@.true = private unnamed_addr constant [5 x i8] c"true\00"
+@.false = private unnamed_addr constant [6 x i8] c"false\00"
+
+declare i32 @printf(i8*, ...)
+declare i32 @puts(i8*)
+
+define i32 @main() {
+entry:
+  %varX_int = alloca i64
+  %varI_int = alloca i64
+  store i64 3, i64* %varX_int
+  store i64 0, i64* %varI_int
+  br label %cond1
+cond1:
+  %t1 = load i64, i64* %varI_int
+  %t2 = mul i64 8, 5
+  %t3 = icmp slt i64 %t1, %t2
+  br i1 %t3, label %body1, label %done1
+body1:
+  %t4 = load i64, i64* %varX_int
+  %t5 = mul i64 %t4, 7
+  %t6 = add i64 %t5, 3
+  store i64 %t6, i64* %varX_int
+  %t7 = load i64, i64* %varI_int
+  %t8 = add i64 %t7, 1
+  store i64 %t8, i64* %varI_int
+  br label %cond1
+done1:
+  %t9 = load i64, i64* %varX_int
+  call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0), i64 %t9)
+  %t10 = load i64, i64* %varX_int
+  %t11 = icmp slt i64 %t10, 0
+  %t12 = select i1 %t11, i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.true, i64 0, i64 0), i8* getelementptr inbounds ([6 x i8], [6 x i8]* @.false, i64 0, i64 0)
+  call i32 @puts(i8* %t12)
+  ret i32 0
+}
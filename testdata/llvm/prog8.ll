@@ -0,0 +1,43 @@
+@.fmt_int = private unnamed_addr constant [5 x i8] c"%ld\0A\00"
+@.true = private unnamed_addr constant [5 x i8] c"true\00"
+@.false = private unnamed_addr constant [6 x i8] c"false\00"
+
+declare i32 @printf(i8*, ...)
+declare i32 @puts(i8*)
+
+define i32 @main() {
+entry:
+  %varX_int = alloca i64
+  store i64 1, i64* %varX_int
+  %t1 = load i64, i64* %varX_int
+  call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0), i64 %t1)
+  %t2 = load i64, i64* %varX_int
+  %t3 = icmp eq i64 %t2, 1
+  br i1 %t3, label %ok1, label %fail1
+fail1:
+  call i32 (i32, i8*, ...) @dprintf(i32 2, i8* getelementptr inbounds ([40 x i8], [40 x i8]* @.assert1, i64 0, i64 0))
+  call void @exit(i32 1)
+  unreachable
+ok1:
+  %t4 = load i64, i64* %varX_int
+  %t5 = add i64 %t4, 1
+  store i64 %t5, i64* %varX_int
+  %t6 = load i64, i64* %varX_int
+  call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0), i64 %t6)
+  %t7 = load i64, i64* %varX_int
+  %t8 = icmp eq i64 %t7, 1
+  br i1 %t8, label %ok2, label %fail2
+fail2:
+  call i32 (i32, i8*, ...) @dprintf(i32 2, i8* getelementptr inbounds ([40 x i8], [40 x i8]* @.assert2, i64 0, i64 0))
+  call void @exit(i32 1)
+  unreachable
+ok2:
+  call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([5 x i8], [5 x i8]* @.fmt_int, i64 0, i64 0), i64 5)
+  ret i32 0
+}
+
+@.assert1 = private unnamed_addr constant [40 x i8] c"1:23-1:39: assertion failed: (varX==1)\0A\00"
+@.assert2 = private unnamed_addr constant [40 x i8] c"1:68-1:84: assertion failed: (varX==1)\0A\00"
+
+declare i32 @dprintf(i32, i8*, ...)
+declare void @exit(i32)
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Verification conditions
+//
+// The annotations assert e, assume e and the invariant of a while loop are
+// turned into formulas by computing weakest preconditions backwards over the
+// program. Every proof obligation, an assert, an invariant on entry to its
+// loop and an invariant preserved by the loop body, gets a verification
+// condition of its own in which the other obligations are assumed to hold.
+// A loop without an invariant has the invariant true. Integers are unbounded
+// in the formulas, overflows are not considered.
+
+// Implication, only used in verification conditions
+type Implies [2]Exp
+
+func (e Implies) pretty() string {
+	return "(" + e[0].pretty() + " ==> " + e[1].pretty() + ")"
+}
+
+func (e Implies) eval(s ValState) Val {
+	b1 := e[0].eval(s)
+	if b1.flag == ValueBool && !b1.valB {
+		return mkBool(true)
+	}
+	return e[1].eval(s)
+}
+
+func (e Implies) infer(t TyState) (Type, ErrorCodeExpression) {
+	t1, _ := e[0].infer(t)
+	t2, _ := e[1].infer(t)
+	if t1 == TyBool && t2 == TyBool {
+		return TyBool, Booleans
+	}
+	return TyIllTyped, Booleans
+}
+
+// Formulas simplified while they are built
+
+func implies(a, b Exp) Exp {
+	switch {
+	case isBool(a, true):
+		return b
+	case isBool(a, false) || isBool(b, true):
+		return Bool(true)
+	}
+	return Implies{a, b}
+}
+
+func conj(a, b Exp) Exp {
+	switch {
+	case isBool(a, false) || isBool(b, false):
+		return Bool(false)
+	case isBool(a, true):
+		return b
+	case isBool(b, true):
+		return a
+	}
+	return And{a, b}
+}
+
+// Folds the constants left by substitution, like 0 <= 10
+func simplify(e Exp) Exp {
+	switch e := e.(type) {
+	case Implies:
+		return implies(simplify(e[0]), simplify(e[1]))
+	case And:
+		return conj(simplify(e[0]), simplify(e[1]))
+	}
+	return optExp(e)
+}
+
+// e with every occurrence of the variable x replaced by r
+func subst(e Exp, x string, r Exp) Exp {
+	switch e := e.(type) {
+	case Var:
+		if string(e) == x {
+			return r
+		}
+		return e
+	case Neg:
+		return Neg{subst(e[0], x, r)}
+	case Implies:
+		return Implies{subst(e[0], x, r), subst(e[1], x, r)}
+	}
+	c := expChildren(e)
+	if len(c) == 2 {
+		return binaryNodes[kindOf(e)]([2]Exp{subst(c[0], x, r), subst(c[1], x, r)})
+	}
+	return e
+}
+
+// Verification condition, f has to be valid for all values of vars
+type VC struct {
+	what   string
+	span   Span
+	f      Exp
+	vars   []typedVar
+	status string // valid, invalid or unknown
+}
+
+// Formula that has to be valid, t holds the types of its variables
+type vcGoal struct {
+	f Exp
+	t TyState
+}
+
+type vcGen struct {
+	active int // obligation to prove, -1 only records the obligations
+	n      int
+	what   []string
+	spans  []Span
+	goals  []vcGoal
+}
+
+// Numbers the obligations in the order wp meets them, reports whether the
+// obligation is the one being proved
+func (g *vcGen) obligation(what string, sp Span) bool {
+	k := g.n
+	g.n++
+	if g.active < 0 {
+		g.what = append(g.what, what)
+		g.spans = append(g.spans, sp)
+	}
+	return k == g.active
+}
+
+// Weakest precondition of s and q, t holds the types before s. The
+// conditions of loops are added to g.goals.
+func (g *vcGen) wp(s Stmt, t TyState, q Exp) Exp {
+	switch s := s.(type) {
+	case ComS:
+		t1 := copyTyState(t)
+		typeStmt(s[0], t1)
+		return g.wp(s[0], t, g.wp(s[1], t1, q))
+	case Decl:
+		return subst(q, s.lhs, s.rhs)
+	case Assign:
+		return subst(q, s.name, s.value)
+	case Assert:
+		if g.obligation("assert "+s.e.pretty(), s.span) {
+			return conj(s.e, q)
+		}
+		return implies(s.e, q)
+	case Assume:
+		return implies(s.e, q)
+	case IfEl:
+		q2 := g.wp(s.b2.s, copyTyState(t), q)
+		q1 := g.wp(s.b1.s, copyTyState(t), q)
+		return conj(implies(s.e, q1), implies(Neg{s.e}, q2))
+	case While:
+		var inv Exp = Bool(true)
+		if s.inv != nil {
+			inv = s.inv
+		}
+		loop := "while " + s.e.pretty()
+		// The loop is left with the invariant and the negated condition
+		g.goals = append(g.goals, vcGoal{implies(conj(inv, Neg{s.e}), q), t})
+		var post Exp = Bool(true)
+		if g.obligation("invariant "+inv.pretty()+" is preserved by "+loop, s.span) {
+			post = inv
+		}
+		body := g.wp(s.b.s, copyTyState(t), post)
+		g.goals = append(g.goals, vcGoal{implies(conj(inv, s.e), body), t})
+		if g.obligation("invariant "+inv.pretty()+" holds on entry to "+loop, s.span) {
+			return inv
+		}
+		return Bool(true)
+	}
+	return q
+}
+
+// One condition per obligation in source order. Conditions that simplify to
+// true or false are decided, the others are left to a solver.
+func verificationConditions(b Block) []VC {
+	all := &vcGen{active: -1}
+	all.wp(b.s, TyState{}, Bool(true))
+	var vcs []VC
+	// wp runs backwards, the last obligation is met first
+	for k := all.n - 1; k >= 0; k-- {
+		g := &vcGen{active: k}
+		g.goals = append(g.goals, vcGoal{g.wp(b.s, TyState{}, Bool(true)), TyState{}})
+		var parts []VC
+		for _, goal := range g.goals {
+			f := simplify(goal.f)
+			if isBool(f, true) {
+				continue
+			}
+			var vars []typedVar
+			for _, x := range expVars(f) {
+				ty, ok := goal.t[x]
+				if !ok {
+					ty = TyInt
+				}
+				vars = append(vars, typedVar{x, ty})
+			}
+			sort.Slice(vars, func(i, j int) bool { return vars[i].name < vars[j].name })
+			parts = append(parts, VC{what: all.what[k], span: all.spans[k], f: f, vars: vars})
+		}
+		if len(parts) == 0 {
+			parts = append(parts, VC{what: all.what[k], span: all.spans[k], f: Bool(true)})
+		}
+		for _, vc := range parts {
+			switch {
+			case isBool(vc.f, true):
+				vc.status = "valid"
+			case isBool(vc.f, false):
+				vc.status = "invalid"
+			default:
+				vc.status = "unknown"
+			}
+			vcs = append(vcs, vc)
+		}
+	}
+	return vcs
+}
+
+// Exit code of imp verify: 1 if a condition is invalid, else 3 if one is
+// left unknown, else 0
+func verifyExitCode(vcs []VC) int {
+	code := 0
+	for _, vc := range vcs {
+		switch {
+		case vc.status == "invalid":
+			return 1
+		case vc.status == "unknown":
+			code = 3
+		}
+	}
+	return code
+}
+
+func (vc VC) pretty() string {
+	x := vc.what + ": " + vc.status
+	if sp := vc.span.pretty(); sp != "" {
+		x = sp + ": " + x
+	}
+	return x + "\n\t" + vc.f.pretty()
+}
+
+// SMT-LIB 2
+
+var smtOps = map[string]string{
+	"Plus": "+", "Minus": "-", "Mult": "*", "And": "and", "Or": "or", "Implies": "=>",
+	"Equ": "=", "Neq": "distinct", "Les": "<", "Leq": "<=", "Gre": ">", "Geq": ">=",
+}
+
+// Names of SMT-LIB that IMP allows as variable names
+var smtReserved = map[string]bool{
+	"and": true, "or": true, "not": true, "distinct": true, "ite": true, "let": true,
+	"forall": true, "exists": true, "div": true, "mod": true, "abs": true, "xor": true,
+}
+
+func smtName(x string) string {
+	if smtReserved[x] {
+		return "|" + x + "|"
+	}
+	return x
+}
+
+func smtSort(ty Type) string {
+	if ty == TyBool {
+		return "Bool"
+	}
+	return "Int"
+}
+
+func smtExp(e Exp) string {
+	switch e := e.(type) {
+	case Num:
+		if e < 0 {
+			// -e overflows for the smallest int
+			return "(- " + strings.TrimPrefix(strconv.Itoa(int(e)), "-") + ")"
+		}
+		return strconv.Itoa(int(e))
+	case Bool:
+		return e.pretty()
+	case Var:
+		return smtName(string(e))
+	case Neg:
+		return "(not " + smtExp(e[0]) + ")"
+	}
+	c := expChildren(e)
+	return "(" + smtOps[kindOf(e)] + " " + smtExp(c[0]) + " " + smtExp(c[1]) + ")"
+}
+
+// Script checking every condition in turn, a condition is valid if the
+// solver answers unsat for its negation
+func smtScript(vcs []VC) string {
+	var x string
+	x = "(set-logic ALL)\n"
+	for i, vc := range vcs {
+		x += fmt.Sprintf("\n; VC %d: %s", i+1, vc.what)
+		if sp := vc.span.pretty(); sp != "" {
+			x += " at " + sp
+		}
+		x += "\n(push 1)\n"
+		for _, v := range vc.vars {
+			x += "(declare-const " + smtName(v.name) + " " + smtSort(v.ty) + ")\n"
+		}
+		x += "(assert (not " + smtExp(vc.f) + "))\n"
+		x += "(check-sat)\n"
+		x += "(pop 1)\n"
+	}
+	return x
+}
+
+// Solvers reading SMT-LIB 2 from standard input
+var smtSolvers = []struct {
+	name string
+	args []string
+}{
+	{"z3", []string{"-in", "-smt2"}},
+	{"cvc5", []string{"--lang=smt2", "--incremental"}},
+}
+
+// Runs the script with the named solver or the first one installed if name
+// is auto, returns the name of the solver used, "" if none was found
+func runSolver(name, script string) (string, string, error) {
+	for _, s := range smtSolvers {
+		if name != "auto" && name != s.name {
+			continue
+		}
+		if !haveTools(s.name) {
+			if name == s.name {
+				return "", "", fmt.Errorf("solver %s not found", name)
+			}
+			continue
+		}
+		cmd := exec.Command(s.name, s.args...)
+		cmd.Stdin = strings.NewReader(script)
+		out, err := cmd.Output()
+		if err != nil && len(out) == 0 {
+			return s.name, "", err
+		}
+		return s.name, string(out), nil
+	}
+	if name != "auto" {
+		return "", "", fmt.Errorf("unknown solver %s", name)
+	}
+	return "", "", nil
+}
+
+// Sets the status of the conditions from the answers of a solver to the
+// script of smtScript
+func applyAnswers(vcs []VC, out string) error {
+	var answers []string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "sat" || line == "unsat" || line == "unknown":
+			answers = append(answers, line)
+		case strings.HasPrefix(line, "(error"):
+			return fmt.Errorf("solver: %s", line)
+		}
+	}
+	if len(answers) != len(vcs) {
+		return fmt.Errorf("solver gave %d answers for %d conditions", len(answers), len(vcs))
+	}
+	for i, a := range answers {
+		switch a {
+		case "unsat":
+			vcs[i].status = "valid"
+		case "sat":
+			vcs[i].status = "invalid"
+		default:
+			vcs[i].status = "unknown"
+		}
+	}
+	return nil
+}
+
+func testVerifyProgram(s string) {
+	fmt.Printf("\n Input: %s", s)
+	_, _, b := parse(s)
+	for i, vc := range verificationConditions(b) {
+		fmt.Printf("\n VC %d %s", i+1, strings.ReplaceAll(vc.pretty(), "\n\t", "\n   "))
+	}
+	fmt.Printf("\n")
+}
+
+var verifyLoopExample = "{varN:=9; varI:=0; while varI < varN invariant varI <= varN {varI = varI+1}; assert varI == varN}"
+
+func testVerify() {
+	fmt.Printf("\n Test 37.1 - Verification Conditions - decided by simplification \n")
+	testVerifyProgram("{varX:=2; varY:=varX+3; assert varY == 5; assume varX > 0; assert varX*varY > 0}")
+	testVerifyProgram("{varX:=5; if varX < 3 {varY:=1} else {varY:=2}; assert varY == 1}")
+	fmt.Printf("\n Test 37.2 - Verification Conditions - loop invariant \n")
+	testVerifyProgram(verifyLoopExample)
+	testVerifyProgram("{varX:=0; varY:=0; while varX < 5 {varX = varX+1; varY = varY+2}; assert varY == 2*varX}")
+	fmt.Printf("\n Test 37.3 - Verification Conditions - assume \n")
+	testVerifyProgram("{varX:=0; varY:=varX; while varY < 3 {varY = varY+1}; assume varX < varY; assert varY > 0}")
+	fmt.Printf("\n Test 37.4 - Verification Conditions - SMT-LIB 2 \n")
+	_, _, b := parse(verifyLoopExample)
+	vcs := verificationConditions(b)
+	fmt.Printf("\n Input: %s\n\n%s", verifyLoopExample, smtScript(vcs))
+	fmt.Printf("\n Test 37.5 - Verification Conditions - answers of a solver \n")
+	err := applyAnswers(vcs, "unsat\nunsat\nunsat\n")
+	fmt.Printf("\n Answers: unsat unsat unsat, error: %v", err)
+	for i, vc := range vcs {
+		fmt.Printf("\n VC %d %s: %s", i+1, vc.what, vc.status)
+	}
+	fmt.Printf("\n Exit code: %d", verifyExitCode(vcs))
+	err = applyAnswers(vcs, "unsat\nunknown\nunsat\n")
+	fmt.Printf("\n Answers: unsat unknown unsat, error: %v, exit code: %d", err, verifyExitCode(vcs))
+	err = applyAnswers(vcs, "unsat\nunknown\nsat\n")
+	fmt.Printf("\n Answers: unsat unknown sat, error: %v, exit code: %d", err, verifyExitCode(vcs))
+	err = applyAnswers(vcs, "unsat\n(error \"line 5 column 10: unknown constant varK\")\n")
+	fmt.Printf("\n Answers: unsat (error ...), error: %v\n", err)
+	fmt.Printf("\n Test 37.6 - Verification Conditions - ill-typed annotations \n")
+	test("{varX:=1; assert varX+1}")
+	test("{varX:=1; while varX < 3 invariant varX {varX = varX+1}}")
+	fmt.Printf("\n Test 37.7 - Verification Conditions - negative numbers in SMT-LIB 2 \n")
+	for _, e := range []Exp{Num(-5), Les{Num(math.MinInt64), Var("varX")}} {
+		fmt.Printf("\n Expression: %s\n SMT-LIB 2: %s\n", e.pretty(), smtExp(e))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+// Helpers to walk the AST
+
+// Direct subexpressions of an expression
+func expChildren(e Exp) []Exp {
+	switch e := e.(type) {
+	case Plus:
+		return e[:]
+	case Minus:
+		return e[:]
+	case Mult:
+		return e[:]
+	case And:
+		return e[:]
+	case Or:
+		return e[:]
+	case Neg:
+		return e[:]
+	case Equ:
+		return e[:]
+	case Neq:
+		return e[:]
+	case Les:
+		return e[:]
+	case Leq:
+		return e[:]
+	case Gre:
+		return e[:]
+	case Geq:
+		return e[:]
+	case Implies:
+		return e[:]
+	}
+	return nil
+}
+
+// Variables read by an expression, in order of first occurrence
+func expVars(e Exp) []string {
+	var names []string
+	seen := map[string]bool{}
+	var walk func(e Exp)
+	walk = func(e Exp) {
+		if x, ok := e.(Var); ok {
+			if !seen[string(x)] {
+				seen[string(x)] = true
+				names = append(names, string(x))
+			}
+			return
+		}
+		for _, f := range expChildren(e) {
+			walk(f)
+		}
+	}
+	walk(e)
+	return names
+}
+
+// Variables a statement declares or assigns, in order of first occurrence
+func assignedVars(s Stmt) []string {
+	var names []string
+	seen := map[string]bool{}
+	var walk func(s Stmt)
+	walk = func(s Stmt) {
+		var x string
+		switch s := s.(type) {
+		case ComS:
+			walk(s[0])
+			walk(s[1])
+			return
+		case IfEl:
+			walk(s.b1.s)
+			walk(s.b2.s)
+			return
+		case While:
+			walk(s.b.s)
+			return
+		case Decl:
+			x = s.lhs
+		case Assign:
+			x = s.name
+		default:
+			return
+		}
+		if !seen[x] {
+			seen[x] = true
+			names = append(names, x)
+		}
+	}
+	walk(s)
+	return names
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WebAssembly backend
+//
+// Translates a checked Block into a module with one exported function main.
+// Ints are i64, bools i32, every variable is a local of main. print is
+// imported from the host as env.print_i64 and env.print_bool. A failing
+// assert calls env.assert_failed with its line, which should stop the
+// program, and traps if it returns. The code is a list of instructions, which
+// is printed as WAT or encoded as a .wasm binary.
+
+type wasmInstr struct {
+	op  string
+	arg int64 // constant, local index, function index or label depth
+}
+
+type wasmModule struct {
+	locals []typedVar
+	code   []wasmInstr
+}
+
+// Functions imported from the host, in order of their index
+var wasmImports = []struct {
+	name  string
+	param Type
+}{
+	{"print_i64", TyInt},
+	{"print_bool", TyBool},
+	{"assert_failed", TyInt},
+}
+
+// Opcodes of the instructions the generator emits
+var wasmOpcodes = map[string]byte{
+	"unreachable": 0x00, "block": 0x02, "loop": 0x03, "if": 0x04, "else": 0x05, "end": 0x0B,
+	"br": 0x0C, "br_if": 0x0D, "call": 0x10,
+	"local.get": 0x20, "local.set": 0x21,
+	"i32.const": 0x41, "i64.const": 0x42,
+	"i32.eqz": 0x45, "i32.eq": 0x46, "i32.ne": 0x47,
+	"i64.eq": 0x51, "i64.ne": 0x52, "i64.lt_s": 0x53, "i64.gt_s": 0x55,
+	"i64.le_s": 0x57, "i64.ge_s": 0x59,
+	"i32.and": 0x71, "i32.or": 0x72,
+	"i64.add": 0x7C, "i64.sub": 0x7D, "i64.mul": 0x7E,
+}
+
+// Instructions with a blocktype, they are always empty (0x40)
+var wasmBlockOps = map[string]bool{"block": true, "loop": true, "if": true}
+
+type wasmGen struct {
+	m     *wasmModule
+	index map[typedVar]int
+}
+
+func genWasm(b Block) *wasmModule {
+	m := &wasmModule{locals: declaredVars(b)}
+	g := &wasmGen{m: m, index: map[typedVar]int{}}
+	for i, v := range m.locals {
+		g.index[v] = i
+	}
+	g.stmt(b.s, make(TyState))
+	return m
+}
+
+func (g *wasmGen) emit(op string, arg int64) {
+	g.m.code = append(g.m.code, wasmInstr{op, arg})
+}
+
+func (g *wasmGen) stmt(s Stmt, t TyState) {
+	switch s := s.(type) {
+	case ComS:
+		g.stmt(s[0], t)
+		g.stmt(s[1], t)
+	case Decl:
+		g.exp(s.rhs, t)
+		g.emit("local.set", int64(g.index[typedVar{s.lhs, declare(s, t)}]))
+	case Assign:
+		g.exp(s.value, t)
+		g.emit("local.set", int64(g.index[typedVar{s.name, t[s.name]}]))
+	case Print:
+		g.exp(s.e, t)
+		if ty, _ := s.e.infer(t); ty == TyBool {
+			g.emit("call", 1)
+		} else {
+			g.emit("call", 0)
+		}
+	case Assert:
+		g.exp(s.e, t)
+		g.emit("i32.eqz", 0)
+		g.emit("if", 0)
+		g.emit("i64.const", int64(s.span.line))
+		g.emit("call", 2)
+		g.emit("unreachable", 0)
+		g.emit("end", 0)
+	case IfEl:
+		t1, t2 := copyTyState(t), copyTyState(t)
+		g.exp(s.e, t)
+		g.emit("if", 0)
+		g.stmt(s.b1.s, t1)
+		g.emit("else", 0)
+		g.stmt(s.b2.s, t2)
+		g.emit("end", 0)
+		joinTyStates(t, t1, t2, "", "")
+	case While:
+		// block { loop { br_if !e to the end of block; s; br to loop } }
+		g.emit("block", 0)
+		g.emit("loop", 0)
+		g.exp(s.e, t)
+		g.emit("i32.eqz", 0)
+		g.emit("br_if", 1)
+		g.stmt(s.b.s, copyTyState(t))
+		g.emit("br", 0)
+		g.emit("end", 0)
+		g.emit("end", 0)
+	}
+}
+
+func (g *wasmGen) exp(e Exp, t TyState) {
+	switch e := e.(type) {
+	case Num:
+		g.emit("i64.const", int64(e))
+		return
+	case Bool:
+		if e {
+			g.emit("i32.const", 1)
+		} else {
+			g.emit("i32.const", 0)
+		}
+		return
+	case Var:
+		x := string(e)
+		g.emit("local.get", int64(g.index[typedVar{x, t[x]}]))
+		return
+	case Neg:
+		g.exp(e[0], t)
+		g.emit("i32.eqz", 0)
+		return
+	}
+	c := expChildren(e)
+	g.exp(c[0], t)
+	g.exp(c[1], t)
+	op := map[string]string{
+		"Plus": "i64.add", "Minus": "i64.sub", "Mult": "i64.mul",
+		"And": "i32.and", "Or": "i32.or",
+		"Equ": "i64.eq", "Neq": "i64.ne",
+		"Les": "i64.lt_s", "Leq": "i64.le_s", "Gre": "i64.gt_s", "Geq": "i64.ge_s",
+	}[kindOf(e)]
+	// Equality of bools compares i32 values
+	if ty, _ := c[0].infer(t); ty == TyBool {
+		op = strings.Replace(op, "i64", "i32", 1)
+	}
+	g.emit(op, 0)
+}
+
+func wasmType(ty Type) string {
+	if ty == TyBool {
+		return "i32"
+	}
+	return "i64"
+}
+
+// Text format
+
+func (m *wasmModule) wat() string {
+	var x string
+	x = "(module\n"
+	for _, f := range wasmImports {
+		x += fmt.Sprintf("  (import \"env\" %q (func $%s (param %s)))\n", f.name, f.name, wasmType(f.param))
+	}
+	x += "  (func $main (export \"main\")\n"
+	for _, v := range m.locals {
+		x += fmt.Sprintf("    (local $%s %s)\n", typedName(v.name, v.ty), wasmType(v.ty))
+	}
+	indent := 2
+	for _, in := range m.code {
+		if in.op == "end" || in.op == "else" {
+			indent--
+		}
+		x += strings.Repeat("  ", indent) + in.op
+		switch in.op {
+		case "local.get", "local.set":
+			v := m.locals[in.arg]
+			x += " $" + typedName(v.name, v.ty)
+		case "call":
+			x += " $" + wasmImports[in.arg].name
+		case "i32.const", "i64.const", "br", "br_if":
+			x += fmt.Sprintf(" %d", in.arg)
+		}
+		x += "\n"
+		if wasmBlockOps[in.op] || in.op == "else" {
+			indent++
+		}
+	}
+	x += "  )\n)\n"
+	return x
+}
+
+// Binary format
+
+func appendULEB(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7F)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendSLEB(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7F)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendName(b []byte, s string) []byte {
+	return append(appendULEB(b, uint64(len(s))), s...)
+}
+
+func wasmValType(ty Type) byte {
+	if ty == TyBool {
+		return 0x7F
+	}
+	return 0x7E
+}
+
+func (m *wasmModule) binary() []byte {
+	section := func(b []byte, id byte, content []byte) []byte {
+		b = append(b, id)
+		b = appendULEB(b, uint64(len(content)))
+		return append(b, content...)
+	}
+	bin := []byte{0x00, 0x61, 0x73, 0x6D, 0x01, 0x00, 0x00, 0x00}
+
+	// Types: one per import, the last one () -> () for main
+	types := appendULEB(nil, uint64(len(wasmImports)+1))
+	for _, f := range wasmImports {
+		types = append(types, 0x60, 0x01, wasmValType(f.param), 0x00)
+	}
+	types = append(types, 0x60, 0x00, 0x00)
+	bin = section(bin, 1, types)
+
+	imports := appendULEB(nil, uint64(len(wasmImports)))
+	for i, f := range wasmImports {
+		imports = appendName(imports, "env")
+		imports = appendName(imports, f.name)
+		imports = append(imports, 0x00)
+		imports = appendULEB(imports, uint64(i))
+	}
+	bin = section(bin, 2, imports)
+
+	main := uint64(len(wasmImports))
+	bin = section(bin, 3, appendULEB([]byte{0x01}, main))
+
+	exports := appendName([]byte{0x01}, "main")
+	exports = append(exports, 0x00)
+	bin = section(bin, 7, appendULEB(exports, main))
+
+	body := appendULEB(nil, uint64(len(m.locals)))
+	for _, v := range m.locals {
+		body = append(body, 0x01, wasmValType(v.ty))
+	}
+	for _, in := range m.code {
+		body = append(body, wasmOpcodes[in.op])
+		switch {
+		case wasmBlockOps[in.op]:
+			body = append(body, 0x40)
+		case in.op == "i32.const" || in.op == "i64.const":
+			body = appendSLEB(body, in.arg)
+		case in.op == "local.get" || in.op == "local.set" || in.op == "call" ||
+			in.op == "br" || in.op == "br_if":
+			body = appendULEB(body, uint64(in.arg))
+		}
+	}
+	body = append(body, 0x0B)
+	code := []byte{0x01}
+	code = appendULEB(code, uint64(len(body)))
+	bin = section(bin, 10, append(code, body...))
+	return bin
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// A tiny WebAssembly runner
+//
+// Decodes a module as produced by wasmModule.binary and runs its main
+// function, so the backend can be tested without a browser or wasm runtime.
+// Only the instructions the generator emits are supported, anything else is
+// reported as an error. Values of both i32 and i64 are kept as int64.
+
+type wasmReader struct {
+	b   []byte
+	pos int
+}
+
+var errWasmEOF = errors.New("unexpected end of module")
+
+func (r *wasmReader) byte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, errWasmEOF
+	}
+	r.pos++
+	return r.b[r.pos-1], nil
+}
+
+func (r *wasmReader) uleb() (uint64, error) {
+	var v uint64
+	for shift := 0; shift < 64; shift += 7 {
+		c, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(c&0x7F) << shift
+		if c&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("LEB128 number too long")
+}
+
+func (r *wasmReader) sleb() (int64, error) {
+	var v int64
+	shift := 0
+	for {
+		c, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		v |= int64(c&0x7F) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			if shift < 64 && c&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v, nil
+		}
+		if shift >= 64 {
+			return 0, errors.New("LEB128 number too long")
+		}
+	}
+}
+
+func (r *wasmReader) name() (string, error) {
+	n, err := r.uleb()
+	if err != nil {
+		return "", err
+	}
+	if r.pos+int(n) > len(r.b) {
+		return "", errWasmEOF
+	}
+	r.pos += int(n)
+	return string(r.b[r.pos-int(n) : r.pos]), nil
+}
+
+// Decodes the imports and the body of main, locals are named by their index
+func decodeWasm(bin []byte) (*wasmModule, error) {
+	if len(bin) < 8 || !bytes.Equal(bin[:8], []byte{0x00, 0x61, 0x73, 0x6D, 0x01, 0x00, 0x00, 0x00}) {
+		return nil, errors.New("not a WebAssembly module (version 1)")
+	}
+	ops := map[byte]string{}
+	for op, c := range wasmOpcodes {
+		ops[c] = op
+	}
+	r := &wasmReader{b: bin, pos: 8}
+	m := &wasmModule{}
+	haveCode := false
+	for r.pos < len(bin) {
+		id, _ := r.byte()
+		size, err := r.uleb()
+		if err != nil {
+			return nil, err
+		}
+		end := r.pos + int(size)
+		if end > len(bin) {
+			return nil, errWasmEOF
+		}
+		switch id {
+		case 2:
+			n, _ := r.uleb()
+			if int(n) != len(wasmImports) {
+				return nil, fmt.Errorf("module imports %d functions, want %d", n, len(wasmImports))
+			}
+			for i := range wasmImports {
+				mod, _ := r.name()
+				name, err := r.name()
+				if err != nil {
+					return nil, err
+				}
+				if mod != "env" || name != wasmImports[i].name {
+					return nil, fmt.Errorf("unknown import %s.%s", mod, name)
+				}
+				r.byte()
+				r.uleb()
+			}
+		case 10:
+			if n, _ := r.uleb(); n != 1 {
+				return nil, fmt.Errorf("module defines %d functions, want 1", n)
+			}
+			r.uleb()
+			groups, _ := r.uleb()
+			for i := uint64(0); i < groups; i++ {
+				n, _ := r.uleb()
+				vt, err := r.byte()
+				if err != nil {
+					return nil, err
+				}
+				ty := TyInt
+				if vt == 0x7F {
+					ty = TyBool
+				}
+				for j := uint64(0); j < n; j++ {
+					m.locals = append(m.locals, typedVar{strconv.Itoa(len(m.locals)), ty})
+				}
+			}
+			// The body ends with the end of main, it is not part of code
+			for r.pos < end-1 {
+				c, _ := r.byte()
+				op, ok := ops[c]
+				if !ok {
+					return nil, fmt.Errorf("unsupported opcode 0x%02X at byte %d", c, r.pos-1)
+				}
+				in := wasmInstr{op: op}
+				switch {
+				case wasmBlockOps[op]:
+					if bt, _ := r.byte(); bt != 0x40 {
+						return nil, fmt.Errorf("unsupported blocktype 0x%02X", bt)
+					}
+				case op == "i32.const" || op == "i64.const":
+					in.arg, err = r.sleb()
+				case op == "local.get" || op == "local.set" || op == "call" || op == "br" || op == "br_if":
+					var v uint64
+					v, err = r.uleb()
+					in.arg = int64(v)
+				}
+				if err != nil {
+					return nil, err
+				}
+				m.code = append(m.code, in)
+			}
+			if c, err := r.byte(); err != nil || c != 0x0B {
+				return nil, errors.New("body of main does not end with end")
+			}
+			haveCode = true
+		}
+		r.pos = end
+	}
+	if !haveCode {
+		return nil, errors.New("module has no code section")
+	}
+	return m, nil
+}
+
+// Label of a block, loop or if that is being executed
+type wasmLabel struct {
+	loop  bool
+	start int // first instruction of the body
+	end   int // the matching end
+}
+
+// Decodes and runs main of a module, print writes to w
+func runWasmBinary(bin []byte, w io.Writer) error {
+	m, err := decodeWasm(bin)
+	if err != nil {
+		return err
+	}
+	// Matching else and end of every block, loop and if
+	elseOf, endOf := map[int]int{}, map[int]int{}
+	var open []int
+	for i, in := range m.code {
+		switch {
+		case wasmBlockOps[in.op]:
+			open = append(open, i)
+		case in.op == "else" && len(open) > 0:
+			elseOf[open[len(open)-1]] = i
+		case in.op == "end":
+			if len(open) == 0 {
+				return fmt.Errorf("end without block at instruction %d", i)
+			}
+			endOf[open[len(open)-1]] = i
+			open = open[:len(open)-1]
+		}
+	}
+	if len(open) > 0 {
+		return errors.New("block without end")
+	}
+
+	locals := make([]int64, len(m.locals))
+	var stack []int64
+	var labels []wasmLabel
+	pop := func() (int64, error) {
+		if len(stack) == 0 {
+			return 0, errors.New("stack underflow")
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, nil
+	}
+	b2i := func(b bool) int64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	branch := func(depth int64, pc *int) error {
+		if int(depth) >= len(labels) {
+			return fmt.Errorf("branch depth %d out of range", depth)
+		}
+		l := labels[len(labels)-1-int(depth)]
+		if l.loop {
+			labels = labels[:len(labels)-int(depth)]
+			*pc = l.start
+		} else {
+			labels = labels[:len(labels)-1-int(depth)]
+			*pc = l.end + 1
+		}
+		return nil
+	}
+	for pc := 0; pc < len(m.code); {
+		in := m.code[pc]
+		pc++
+		switch in.op {
+		case "block", "loop":
+			labels = append(labels, wasmLabel{in.op == "loop", pc, endOf[pc-1]})
+		case "if":
+			c, err := pop()
+			if err != nil {
+				return err
+			}
+			l := wasmLabel{false, pc, endOf[pc-1]}
+			if c != 0 {
+				labels = append(labels, l)
+			} else if e, ok := elseOf[pc-1]; ok {
+				labels = append(labels, l)
+				pc = e + 1
+			} else {
+				pc = l.end + 1
+			}
+		case "unreachable":
+			return errors.New("unreachable executed")
+		case "else":
+			// End of the then branch
+			pc = labels[len(labels)-1].end + 1
+			labels = labels[:len(labels)-1]
+		case "end":
+			labels = labels[:len(labels)-1]
+		case "br":
+			if err := branch(in.arg, &pc); err != nil {
+				return err
+			}
+		case "br_if":
+			c, err := pop()
+			if err != nil {
+				return err
+			}
+			if c != 0 {
+				if err := branch(in.arg, &pc); err != nil {
+					return err
+				}
+			}
+		case "call":
+			v, err := pop()
+			if err != nil {
+				return err
+			}
+			switch in.arg {
+			case 0:
+				fmt.Fprintln(w, v)
+			case 1:
+				fmt.Fprintln(w, v != 0)
+			case 2:
+				return fmt.Errorf("assertion failed at line %d", v)
+			default:
+				return fmt.Errorf("call of unknown function %d", in.arg)
+			}
+		case "local.get":
+			if int(in.arg) >= len(locals) {
+				return fmt.Errorf("local %d out of range", in.arg)
+			}
+			stack = append(stack, locals[in.arg])
+		case "local.set":
+			v, err := pop()
+			if err != nil {
+				return err
+			}
+			if int(in.arg) >= len(locals) {
+				return fmt.Errorf("local %d out of range", in.arg)
+			}
+			locals[in.arg] = v
+		case "i32.const", "i64.const":
+			stack = append(stack, in.arg)
+		case "i32.eqz":
+			v, err := pop()
+			if err != nil {
+				return err
+			}
+			stack = append(stack, b2i(v == 0))
+		default:
+			y, err := pop()
+			if err != nil {
+				return err
+			}
+			x, err := pop()
+			if err != nil {
+				return err
+			}
+			var v int64
+			switch in.op {
+			case "i64.add":
+				v = x + y
+			case "i64.sub":
+				v = x - y
+			case "i64.mul":
+				v = x * y
+			case "i32.and":
+				v = x & y
+			case "i32.or":
+				v = x | y
+			case "i32.eq", "i64.eq":
+				v = b2i(x == y)
+			case "i32.ne", "i64.ne":
+				v = b2i(x != y)
+			case "i64.lt_s":
+				v = b2i(x < y)
+			case "i64.le_s":
+				v = b2i(x <= y)
+			case "i64.gt_s":
+				v = b2i(x > y)
+			case "i64.ge_s":
+				v = b2i(x >= y)
+			default:
+				return fmt.Errorf("unsupported instruction %s", in.op)
+			}
+			stack = append(stack, v)
+		}
+	}
+	return nil
+}
+
+func runWasm(b Block) (string, error) {
+	var buf bytes.Buffer
+	err := runWasmBinary(genWasm(b).binary(), &buf)
+	return buf.String(), err
+}
+
+// Runs the binary with the WebAssembly engine of node
+func runWasmNode(b Block) (string, error) {
+	js := `const fs = require("fs");
+const m = new WebAssembly.Module(fs.readFileSync("prog.wasm"));
+const i = new WebAssembly.Instance(m, {env: {
+  print_i64: x => console.log(x.toString()),
+  print_bool: x => console.log(x ? "true" : "false"),
+  assert_failed: line => {
+    console.error("assertion failed at line " + line);
+    process.exit(1);
+  },
+}});
+i.exports.main();
+`
+	return runInTempDir(map[string]string{"prog.wasm": string(genWasm(b).binary()), "run.js": js},
+		[]string{"node", "run.js"})
+}
+
+func testWasmBackend() {
+	fmt.Printf("\n Test 30.1 - WebAssembly Backend - generated code \n")
+	src := "{varX:=1;while varX<4 {if varX==2 {print true} else {print varX}; varX = varX+1}}"
+	_, _, b := parse(src)
+	fmt.Printf("\n Input: %s\n\n%s", src, genWasm(b).wat())
+	fmt.Printf("\n Test 30.2 - WebAssembly Backend - binary decodes to the same code \n")
+	for _, src := range backendCorpus {
+		_, _, b := parse(src)
+		m := genWasm(b)
+		d, err := decodeWasm(m.binary())
+		same := err == nil && fmt.Sprint(d.code) == fmt.Sprint(m.code)
+		fmt.Printf("\n Input: %s\n Same Code: %t ", src, same)
+	}
+	fmt.Printf("\n")
+	fmt.Printf("\n Test 30.3 - WebAssembly Backend - runner against the interpreter \n")
+	testBackend("WASM BACKEND", runWasm)
+	fmt.Printf("\n Test 30.4 - WebAssembly Backend - runner rejects bad modules \n")
+	for _, bin := range [][]byte{
+		[]byte("\x00asm\x02\x00\x00\x00"),
+		genWasm(Block{s: Print{e: Num(1)}}).binary()[:20],
+		append([]byte{0x00, 0x61, 0x73, 0x6D, 0x01, 0x00, 0x00, 0x00}, 0x0A, 0x05, 0x01, 0x03, 0x00, 0x6A, 0x0B),
+	} {
+		fmt.Printf("\n Output Runner: %v ", runWasmBinary(bin, io.Discard))
+	}
+	fmt.Printf("\n")
+	fmt.Printf("\n Test 30.5 - WebAssembly Backend - node against the interpreter \n")
+	if !haveTools("node") {
+		fmt.Printf("\n node not found, skipped \n")
+		return
+	}
+	testBackend("WASM BACKEND", runWasmNode)
+}